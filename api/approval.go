@@ -0,0 +1,182 @@
+package pub
+
+import (
+	"fmt"
+	"time"
+)
+
+// Approval statuses of a task, a task requiring approval goes from pending to approved,
+// rejected or expired. The runs of an approved task are told by the status of the task.
+// An approval holds for a single run of a one-shot task, it is renewed to run the task again.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+)
+
+var approvalTransitions = map[string][]string{
+	ApprovalPending: {ApprovalApproved, ApprovalRejected, ApprovalExpired},
+}
+
+// Approval decisions.
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+)
+
+// ApprovalPolicy requires `Required` approvals, 1 when zero, out of the users in
+// `Approvers` or of the roles in `Roles`, the administrators when both are empty.
+// A single rejection rejects the task. The task expires after `Expiry` when set.
+type ApprovalPolicy struct {
+	Required  int        `json:"required"`
+	Roles     []UserRole `json:"roles,omitempty"`
+	Approvers []uint64   `json:"approvers,omitempty"`
+	Expiry    string     `json:"expiry,omitempty"`
+}
+
+// ApprovalDecision is an entry of the approval history of a task.
+type ApprovalDecision struct {
+	UserID   uint64    `json:"user_id"`
+	Username string    `json:"username"`
+	Decision string    `json:"decision"`
+	Comment  string    `json:"comment,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Approval is the approval state of a task, `History` lists the decisions in order.
+type Approval struct {
+	Policy  ApprovalPolicy     `json:"policy"`
+	Status  string             `json:"status"`
+	Updated time.Time          `json:"updated"`
+	Expires time.Time          `json:"expires,omitempty"`
+	History []ApprovalDecision `json:"history,omitempty"`
+	// Previous lists the decisions of the approvals renewed before this one.
+	Previous []ApprovalDecision `json:"previous,omitempty"`
+}
+
+func (p *ApprovalPolicy) Validate() error {
+	if p.Required < 0 {
+		return Error("Required approvals must not be negative")
+	}
+	if len(p.Roles) == 0 && len(p.Approvers) > 0 && p.RequiredApprovals() > len(p.Approvers) {
+		return Error(fmt.Sprintf("Required approvals %d exceed the %d approvers", p.Required, len(p.Approvers)))
+	}
+	if p.Expiry != "" {
+		if d, err := time.ParseDuration(p.Expiry); err != nil || d <= 0 {
+			return Error(fmt.Sprintf("Invalid approval expiry %q", p.Expiry))
+		}
+	}
+	return nil
+}
+
+// RequiredApprovals is the number of approvals a task needs, 1 at least.
+func (p *ApprovalPolicy) RequiredApprovals() int {
+	if p.Required < 1 {
+		return 1
+	}
+	return p.Required
+}
+
+// Eligible reports whether the user may approve or reject under the policy.
+func (p *ApprovalPolicy) Eligible(userID uint64, role UserRole) bool {
+	if len(p.Roles) == 0 && len(p.Approvers) == 0 {
+		return role == AdministratorRole
+	}
+	for _, id := range p.Approvers {
+		if id == userID {
+			return true
+		}
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// NewApproval starts the approval of a task created at the given time,
+// `defaultExpiry` applies when the policy has none.
+func NewApproval(policy ApprovalPolicy, created time.Time, defaultExpiry time.Duration) *Approval {
+	a := &Approval{Policy: policy, Status: ApprovalPending, Updated: created}
+	expiry := defaultExpiry
+	if d, err := time.ParseDuration(policy.Expiry); err == nil && policy.Expiry != "" {
+		expiry = d
+	}
+	if expiry > 0 {
+		a.Expires = created.Add(expiry)
+	}
+	return a
+}
+
+// Renew starts a new pending approval under the same policy at the given time, the
+// decisions so far are moved to Previous.
+func (a *Approval) Renew(at time.Time, defaultExpiry time.Duration) *Approval {
+	renewed := NewApproval(a.Policy, at, defaultExpiry)
+	renewed.Previous = append(append([]ApprovalDecision(nil), a.Previous...), a.History...)
+	return renewed
+}
+
+// Transition moves the status on at the given time, unless the state machine forbids it.
+func (a *Approval) Transition(to string, at time.Time) error {
+	for _, next := range approvalTransitions[a.Status] {
+		if next == to {
+			a.Status, a.Updated = to, at
+			return nil
+		}
+	}
+	return Error(fmt.Sprintf("Task approval cannot go from %s to %s", a.Status, to))
+}
+
+// Expired reports whether the pending approval has expired at the given time.
+func (a *Approval) Expired(at time.Time) bool {
+	return a.Status == ApprovalPending && !a.Expires.IsZero() && !at.Before(a.Expires)
+}
+
+// Approvals is the number of approvals so far.
+func (a *Approval) Approvals() int {
+	var n int
+	for _, d := range a.History {
+		if d.Decision == DecisionApprove {
+			n++
+		}
+	}
+	return n
+}
+
+// Decide records the decision of a user about the task owned by `ownerID` and
+// moves the status on, the owner and the users who decided already are refused.
+func (a *Approval) Decide(ownerID uint64, role UserRole, d ApprovalDecision) error {
+	if a.Status != ApprovalPending {
+		return Error(fmt.Sprintf("Task approval is %s already", a.Status))
+	}
+	if a.Expired(d.Time) {
+		a.Transition(ApprovalExpired, d.Time)
+		return ErrApprovalExpired
+	}
+	if d.UserID == ownerID {
+		return ErrSelfApproval
+	}
+	if !a.Policy.Eligible(d.UserID, role) {
+		return ErrApprovalDenied
+	}
+	for _, prev := range a.History {
+		if prev.UserID == d.UserID {
+			return ErrAlreadyDecided
+		}
+	}
+	switch d.Decision {
+	case DecisionApprove:
+		a.History = append(a.History, d)
+		if a.Approvals() >= a.Policy.RequiredApprovals() {
+			return a.Transition(ApprovalApproved, d.Time)
+		}
+	case DecisionReject:
+		a.History = append(a.History, d)
+		return a.Transition(ApprovalRejected, d.Time)
+	default:
+		return Error(fmt.Sprintf("Unknown decision %q", d.Decision))
+	}
+	return nil
+}
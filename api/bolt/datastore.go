@@ -11,50 +11,84 @@ import (
 )
 
 type Store struct {
-	Path          string // Path where is stored the BoltDB database
-	UserService   *UserService
-	HostService   *HostService
-	MailerService *MailerService
-	TaskService   *TaskService
-	ModuleService *ModuleService
-	db            *bolt.DB
+	Path            string // Path where is stored the BoltDB database
+	UserService     *UserService
+	HostService     *HostService
+	MailerService   *MailerService
+	TaskService     *TaskService
+	ModuleService   *ModuleService
+	PolicyService   *PolicyService
+	WindowService   *WindowService
+	WorkflowService *WorkflowService
+	TemplateService *TemplateService
+	db              *bolt.DB
 }
 
 const (
-	databaseFileName    = "pubmgmt.db"
-	userBucketName      = "users"
-	hostBucketName      = "hosts"
-	hostgroupBucketName = "hostgroups"
-	emailBucketName     = "emails"
-	taskBucketName      = "tasks"
-	cronBucketName      = "crons"
-	svnInfoBucketName   = "svninfos"
+	databaseFileName          = "pubmgmt.db"
+	userBucketName            = "users"
+	hostBucketName            = "hosts"
+	hostgroupBucketName       = "hostgroups"
+	emailBucketName           = "emails"
+	mailQueueBucketName       = "mailqueue"
+	emailTemplateBucketName   = "emailtemplates"
+	smtpProfileBucketName     = "smtpprofiles"
+	taskBucketName            = "tasks"
+	cronBucketName            = "crons"
+	cronRunBucketName         = "cronruns"
+	taskRunBucketName         = "taskruns"
+	svnInfoBucketName         = "svninfos"
+	policyBucketName          = "policies"
+	windowBucketName          = "windows"
+	workflowBucketName        = "workflows"
+	workflowRunBucketName     = "workflowruns"
+	templateBucketName        = "templates"
+	templateVersionBucketName = "templateversions"
 )
 
 var bucketFuncMap = map[string]func() pub.Model{
-	userBucketName:      func() pub.Model { return &pub.User{} },
-	hostBucketName:      func() pub.Model { return &pub.Host{} },
-	hostgroupBucketName: func() pub.Model { return &pub.Hostgroup{} },
-	emailBucketName:     func() pub.Model { return &pub.Email{} },
-	taskBucketName:      func() pub.Model { return &pub.Task{} },
-	cronBucketName:      func() pub.Model { return &pub.Cron{} },
-	svnInfoBucketName:   func() pub.Model { return &pub.SubversionInfo{} },
+	userBucketName:            func() pub.Model { return &pub.User{} },
+	hostBucketName:            func() pub.Model { return &pub.Host{} },
+	hostgroupBucketName:       func() pub.Model { return &pub.Hostgroup{} },
+	emailBucketName:           func() pub.Model { return &pub.Email{} },
+	mailQueueBucketName:       func() pub.Model { return &pub.QueuedEmail{} },
+	emailTemplateBucketName:   func() pub.Model { return &pub.EmailTemplate{} },
+	smtpProfileBucketName:     func() pub.Model { return &pub.SMTPProfile{} },
+	taskBucketName:            func() pub.Model { return &pub.Task{} },
+	cronBucketName:            func() pub.Model { return &pub.Cron{} },
+	cronRunBucketName:         func() pub.Model { return &pub.CronRun{} },
+	svnInfoBucketName:         func() pub.Model { return &pub.SubversionInfo{} },
+	policyBucketName:          func() pub.Model { return &pub.CommandPolicy{} },
+	taskRunBucketName:         func() pub.Model { return &pub.TaskRun{} },
+	windowBucketName:          func() pub.Model { return &pub.MaintenanceWindow{} },
+	workflowBucketName:        func() pub.Model { return &pub.Workflow{} },
+	workflowRunBucketName:     func() pub.Model { return &pub.WorkflowRun{} },
+	templateBucketName:        func() pub.Model { return &pub.TaskTemplate{} },
+	templateVersionBucketName: func() pub.Model { return &pub.TemplateVersion{} },
 }
 
 func NewStore(storePath string) (*Store, error) {
 	store := &Store{
-		Path:          storePath,
-		UserService:   &UserService{},
-		HostService:   &HostService{},
-		MailerService: &MailerService{},
-		TaskService:   &TaskService{},
-		ModuleService: &ModuleService{},
+		Path:            storePath,
+		UserService:     &UserService{},
+		HostService:     &HostService{},
+		MailerService:   &MailerService{},
+		TaskService:     &TaskService{},
+		ModuleService:   &ModuleService{},
+		PolicyService:   &PolicyService{},
+		WindowService:   &WindowService{},
+		WorkflowService: &WorkflowService{},
+		TemplateService: &TemplateService{},
 	}
 	store.UserService.store = store
 	store.HostService.store = store
 	store.MailerService.store = store
 	store.TaskService.store = store
 	store.ModuleService.store = store
+	store.PolicyService.store = store
+	store.WindowService.store = store
+	store.WorkflowService.store = store
+	store.TemplateService.store = store
 	return store, nil
 }
 
@@ -71,7 +105,7 @@ func (store *Store) Open() error {
 				return err
 			}
 		}
-		return nil
+		return migrateRuns(tx)
 	})
 }
 
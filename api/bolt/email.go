@@ -1,15 +1,182 @@
 package bolt
 
 import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
 	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/api/bolt/internal"
 )
 
 type MailerService struct {
 	store *Store
 }
 
+// CreateEmail stores the email, and indexes it in the mail queue while it waits to be sent.
 func (service *MailerService) CreateEmail(email *pub.Email) error {
-	return service.store.createObject(emailBucketName, email)
+	return service.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(emailBucketName))
+		email.ID, _ = bucket.NextSequence()
+		return putEmail(tx, email)
+	})
+}
+
+// UpdateEmail stores the email, and keeps the mail queue in line with its status.
+func (service *MailerService) UpdateEmail(ID uint64, email *pub.Email) error {
+	return service.store.db.Update(func(tx *bolt.Tx) error {
+		email.ID = ID
+		return putEmail(tx, email)
+	})
+}
+
+func (service *MailerService) DeleteEmail(ID uint64) error {
+	return service.store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(mailQueueBucketName)).Delete(internal.Itob(ID)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(emailBucketName)).Delete(internal.Itob(ID))
+	})
+}
+
+func putEmail(tx *bolt.Tx, email *pub.Email) error {
+	data, err := internal.Marshal(email)
+	if err != nil {
+		return err
+	}
+	if err = tx.Bucket([]byte(emailBucketName)).Put(internal.Itob(email.ID), data); err != nil {
+		return err
+	}
+	queue := tx.Bucket([]byte(mailQueueBucketName))
+	if !email.Status.Waiting() {
+		return queue.Delete(internal.Itob(email.ID))
+	}
+	if data, err = internal.Marshal(&pub.QueuedEmail{ID: email.ID, Profile: email.Profile, NextAttempt: email.NextAttempt}); err != nil {
+		return err
+	}
+	return queue.Put(internal.Itob(email.ID), data)
+}
+
+// legacyEmailConfig is the key of the smtp config, credentials included, which
+// emails were stored with before smtp profiles.
+const legacyEmailConfig = "config"
+
+// ScrubEmailConfigs removes the legacy smtp config from the stored emails, it
+// returns how many were rewritten. The other fields are kept as they are stored.
+func (service *MailerService) ScrubEmailConfigs() (int, error) {
+	var scrubbed int
+	err := service.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(emailBucketName))
+		updates := make(map[string][]byte)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if !bytes.Contains(v, []byte(`"`+legacyEmailConfig+`"`)) {
+				continue
+			}
+			var fields map[string]json.RawMessage
+			if err := internal.Unmarshal(v, &fields); err != nil {
+				return err
+			}
+			if _, ok := fields[legacyEmailConfig]; !ok {
+				continue
+			}
+			delete(fields, legacyEmailConfig)
+			data, err := internal.Marshal(fields)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = data
+		}
+		for k, data := range updates {
+			if err := bucket.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+		scrubbed = len(updates)
+		return nil
+	})
+	return scrubbed, err
+}
+
+// QueuedEmails returns the emails waiting to be sent from the mail queue, in the order
+// they were created.
+func (service *MailerService) QueuedEmails() ([]pub.QueuedEmail, error) {
+	var queued []pub.QueuedEmail
+	err := service.store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(mailQueueBucketName)).ForEach(func(k, v []byte) error {
+			var q pub.QueuedEmail
+			if err := internal.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			queued = append(queued, q)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return queued, nil
+}
+
+// PruneEmails deletes the emails sent before the given time, it returns how many.
+func (service *MailerService) PruneEmails(before time.Time) (int, error) {
+	var pruned int
+	err := service.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(emailBucketName))
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var email pub.Email
+			if err := internal.Unmarshal(v, &email); err != nil {
+				return err
+			}
+			if email.Status == pub.EmailSent && email.Done.Before(before) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(expired)
+		return nil
+	})
+	return pruned, err
+}
+
+func (service *MailerService) Email(ID uint64) (*pub.Email, error) {
+	var email pub.Email
+	if err := service.store.getObjectByID(emailBucketName, ID, &email); err != nil {
+		return nil, err
+	}
+	return &email, nil
+}
+
+func (service *MailerService) Emails(filter *pub.EmailFilter) ([]pub.Email, error) {
+	var emails []pub.Email
+	err := service.store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(emailBucketName))
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var email pub.Email
+			if err := internal.Unmarshal(v, &email); err != nil {
+				return err
+			}
+			if filter.Match(&email) {
+				emails = append(emails, email)
+			}
+		}
+		if len(emails) == 0 {
+			return pub.ErrEmailOutboxEmpty
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return emails, nil
 }
 
 func (service *MailerService) EmailByUser(userId uint64) ([]pub.Email, error) {
@@ -39,3 +206,91 @@ func (service *MailerService) EmailByUUID(uuid string) (*pub.Email, error) {
 	}
 	return modelSets[0].(*pub.Email), nil
 }
+
+func (service *MailerService) EmailTemplate(ID uint64) (*pub.EmailTemplate, error) {
+	var tmpl pub.EmailTemplate
+	if err := service.store.getObjectByID(emailTemplateBucketName, ID, &tmpl); err != nil {
+		return nil, err
+	}
+	return &tmpl, nil
+}
+
+func (service *MailerService) EmailTemplateByName(name string) (*pub.EmailTemplate, error) {
+	modelSets, err := service.store.getObjectByFieldName(emailTemplateBucketName, "Name", name)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrEmailTemplateNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return modelSets[0].(*pub.EmailTemplate), nil
+}
+
+func (service *MailerService) EmailTemplates() ([]pub.EmailTemplate, error) {
+	modelSets, err := service.store.getObjectByFieldName(emailTemplateBucketName, "", nil)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrEmailTemplateSetEmpty
+	} else if err != nil {
+		return nil, err
+	}
+	var templates []pub.EmailTemplate
+	for _, m := range modelSets {
+		templates = append(templates, *m.(*pub.EmailTemplate))
+	}
+	return templates, nil
+}
+
+func (service *MailerService) CreateEmailTemplate(tmpl *pub.EmailTemplate) error {
+	return service.store.createObject(emailTemplateBucketName, tmpl)
+}
+
+func (service *MailerService) UpdateEmailTemplate(ID uint64, tmpl *pub.EmailTemplate) error {
+	return service.store.updateObjectByID(emailTemplateBucketName, ID, tmpl)
+}
+
+func (service *MailerService) DeleteEmailTemplate(ID uint64) error {
+	return service.store.deleteObject(emailTemplateBucketName, ID)
+}
+
+func (service *MailerService) SMTPProfile(ID uint64) (*pub.SMTPProfile, error) {
+	var profile pub.SMTPProfile
+	if err := service.store.getObjectByID(smtpProfileBucketName, ID, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (service *MailerService) SMTPProfileByName(name string) (*pub.SMTPProfile, error) {
+	modelSets, err := service.store.getObjectByFieldName(smtpProfileBucketName, "Name", name)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrSMTPProfileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return modelSets[0].(*pub.SMTPProfile), nil
+}
+
+func (service *MailerService) SMTPProfiles() ([]pub.SMTPProfile, error) {
+	modelSets, err := service.store.getObjectByFieldName(smtpProfileBucketName, "", nil)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrSMTPProfileSetEmpty
+	} else if err != nil {
+		return nil, err
+	}
+	var profiles []pub.SMTPProfile
+	for _, m := range modelSets {
+		profiles = append(profiles, *m.(*pub.SMTPProfile))
+	}
+	return profiles, nil
+}
+
+func (service *MailerService) CreateSMTPProfile(profile *pub.SMTPProfile) error {
+	return service.store.createObject(smtpProfileBucketName, profile)
+}
+
+func (service *MailerService) UpdateSMTPProfile(ID uint64, profile *pub.SMTPProfile) error {
+	return service.store.updateObjectByID(smtpProfileBucketName, ID, profile)
+}
+
+func (service *MailerService) DeleteSMTPProfile(ID uint64) error {
+	return service.store.deleteObject(smtpProfileBucketName, ID)
+}
@@ -0,0 +1,47 @@
+package bolt
+
+import (
+	"github.com/fengxsong/pubmgmt/api"
+)
+
+type PolicyService struct {
+	store *Store
+}
+
+func (service *PolicyService) Policy(ID uint64) (*pub.CommandPolicy, error) {
+	var policy pub.CommandPolicy
+	if err := service.store.getObjectByID(policyBucketName, ID, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (service *PolicyService) Policies() ([]pub.CommandPolicy, error) {
+	modelSet, err := service.store.getObjectByFieldName(policyBucketName, "", nil)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrPolicySetEmpty
+	} else if err != nil {
+		return nil, err
+	}
+	return trPolicies(modelSet), nil
+}
+
+func trPolicies(ms []pub.Model) []pub.CommandPolicy {
+	var policies []pub.CommandPolicy
+	for _, m := range ms {
+		policies = append(policies, *m.(*pub.CommandPolicy))
+	}
+	return policies
+}
+
+func (service *PolicyService) UpdatePolicy(ID uint64, policy *pub.CommandPolicy) error {
+	return service.store.updateObjectByID(policyBucketName, ID, policy)
+}
+
+func (service *PolicyService) CreatePolicy(policy *pub.CommandPolicy) error {
+	return service.store.createObject(policyBucketName, policy)
+}
+
+func (service *PolicyService) DeletePolicy(ID uint64) error {
+	return service.store.deleteObject(policyBucketName, ID)
+}
@@ -0,0 +1,121 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/boltdb/bolt"
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/api/bolt/internal"
+)
+
+// runBuckets are the buckets of the runs, keyed by runKey, with the owner of a stored run.
+var runBuckets = map[string]func(data []byte) (uint64, error){
+	cronRunBucketName: func(data []byte) (uint64, error) {
+		var run pub.CronRun
+		err := internal.Unmarshal(data, &run)
+		return run.CronID, err
+	},
+	taskRunBucketName: func(data []byte) (uint64, error) {
+		var run pub.TaskRun
+		err := internal.Unmarshal(data, &run)
+		return run.TaskID, err
+	},
+}
+
+// runKey keys a run by its owner then its ID, the runs of an owner are next to each
+// other in the bucket, from the oldest.
+func runKey(ownerID, ID uint64) []byte {
+	return append(internal.Itob(ownerID), internal.Itob(ID)...)
+}
+
+// migrateRuns moves the runs stored by their ID alone under runKey.
+func migrateRuns(tx *bolt.Tx) error {
+	for name, owner := range runBuckets {
+		bucket := tx.Bucket([]byte(name))
+		var keys, values [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if len(k) == 8 {
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, append([]byte(nil), v...))
+			}
+		}
+		for i, k := range keys {
+			ownerID, err := owner(values[i])
+			if err != nil {
+				return err
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			if err := bucket.Put(runKey(ownerID, binary.BigEndian.Uint64(k)), values[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createRun stores the run of the owner, its ID is the next sequence of the bucket.
+func (store *Store) createRun(bucketName string, ownerID uint64, run interface{}) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		id, _ := bucket.NextSequence()
+		if err := setObjectID(run, id); err != nil {
+			return err
+		}
+		data, err := internal.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(runKey(ownerID, id), data)
+	})
+}
+
+// eachRun calls fn with the key and the value of the runs of the owner, newest first,
+// it only walks the runs of the owner.
+func eachRun(bucket *bolt.Bucket, ownerID uint64, fn func(k, v []byte) error) error {
+	prefix := internal.Itob(ownerID)
+	cursor := bucket.Cursor()
+	k, v := cursor.Seek(internal.Itob(ownerID + 1))
+	if k == nil {
+		k, v = cursor.Last()
+	} else {
+		k, v = cursor.Prev()
+	}
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Prev() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneRuns deletes the runs of the owner but the newest `keep` ones.
+func (store *Store) pruneRuns(bucketName string, ownerID uint64, keep int) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		var (
+			kept    int
+			expired [][]byte
+		)
+		err := eachRun(bucket, ownerID, func(k, v []byte) error {
+			if kept < keep {
+				kept++
+			} else {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
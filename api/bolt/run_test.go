@@ -0,0 +1,118 @@
+package bolt
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/api/bolt/internal"
+)
+
+func openStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "pubmgmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func runIDs(runs []pub.TaskRun) []uint64 {
+	var ids []uint64
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	return ids
+}
+
+func TestTaskRuns(t *testing.T) {
+	store, closeStore := openStore(t)
+	defer closeStore()
+	service := store.TaskService
+	// the runs of the tasks 1, 2 and 3 are interleaved, with IDs 1 to 9.
+	for i := 0; i < 9; i++ {
+		if err := service.CreateTaskRun(&pub.TaskRun{TaskID: uint64(i%3 + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		taskID        uint64
+		offset, limit int
+		want          []uint64
+		total         int
+	}{
+		{1, 0, 0, []uint64{7, 4, 1}, 3},
+		{2, 0, 2, []uint64{8, 5}, 3},
+		{3, 1, 1, []uint64{6}, 3},
+		{3, 5, 1, nil, 3},
+	} {
+		runs, total, err := service.TaskRuns(c.taskID, c.offset, c.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := runIDs(runs); total != c.total || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("TaskRuns(%d, %d, %d) = %v, %d, want %v, %d", c.taskID, c.offset, c.limit, got, total, c.want, c.total)
+		}
+	}
+	if _, _, err := service.TaskRuns(4, 0, 0); err != pub.ErrTaskRunEmpty {
+		t.Errorf("TaskRuns of a task without runs = %v, want %v", err, pub.ErrTaskRunEmpty)
+	}
+
+	if err := service.PruneTaskRuns(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	for taskID, want := range map[uint64]int{1: 3, 2: 1, 3: 3} {
+		if _, total, _ := service.TaskRuns(taskID, 0, 0); total != want {
+			t.Errorf("task %d keeps %d runs after pruning the task 2, want %d", taskID, total, want)
+		}
+	}
+}
+
+func TestMigrateRuns(t *testing.T) {
+	store, closeStore := openStore(t)
+	defer closeStore()
+	// runs stored by their ID alone.
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		for i, run := range []pub.CronRun{{ID: 1, CronID: 2}, {ID: 2, CronID: 1}, {ID: 3, CronID: 2}} {
+			data, err := internal.Marshal(&run)
+			if err != nil {
+				return err
+			}
+			bucket := tx.Bucket([]byte(cronRunBucketName))
+			if err := bucket.Put(internal.Itob(uint64(i+1)), data); err != nil {
+				return err
+			}
+			bucket.SetSequence(uint64(i + 1))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.TaskService.CreateCronRun(&pub.CronRun{CronID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	runs, total, err := store.TaskService.CronRuns(2, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || runs[0].ID != 4 || runs[1].ID != 3 || runs[2].ID != 1 {
+		t.Errorf("CronRuns(2) = %v, want the runs 4, 3 and 1", runs)
+	}
+}
@@ -1,6 +1,8 @@
 package bolt
 
 import (
+	"time"
+
 	"github.com/boltdb/bolt"
 	"github.com/fengxsong/pubmgmt/api"
 	"github.com/fengxsong/pubmgmt/api/bolt/internal"
@@ -18,7 +20,8 @@ func (service *TaskService) Task(ID uint64) (*pub.Task, error) {
 	return &task, nil
 }
 
-func (service *TaskService) Tasks(reqApproval, unfinished bool) ([]pub.Task, error) {
+// Tasks returns the tasks selected by the filter, in the order they were created.
+func (service *TaskService) Tasks(filter *pub.TaskFilter) ([]pub.Task, error) {
 	var tasks []pub.Task
 	err := service.store.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(taskBucketName))
@@ -28,13 +31,7 @@ func (service *TaskService) Tasks(reqApproval, unfinished bool) ([]pub.Task, err
 			if err := internal.Unmarshal(v, &task); err != nil {
 				return err
 			}
-			if reqApproval && task.RequiredApproval == reqApproval {
-				if unfinished && task.Done.IsZero() {
-					tasks = append(tasks, task)
-				} else {
-					tasks = append(tasks, task)
-				}
-			} else {
+			if filter.Match(&task) {
 				tasks = append(tasks, task)
 			}
 		}
@@ -78,6 +75,31 @@ func (service *TaskService) UpdateTask(ID uint64, task *pub.Task) error {
 	return service.store.updateObjectByID(taskBucketName, ID, task)
 }
 
+// UpdateTaskStatus saves the status of the task and the time it was done unless it is zero,
+// its other fields are left as they are in the store.
+func (service *TaskService) UpdateTaskStatus(ID uint64, status pub.TaskStatus, done time.Time) error {
+	return service.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(taskBucketName))
+		v := bucket.Get(internal.Itob(ID))
+		if v == nil {
+			return pub.ErrObjNotFound
+		}
+		var task pub.Task
+		if err := internal.Unmarshal(v, &task); err != nil {
+			return err
+		}
+		task.Status = status
+		if !done.IsZero() {
+			task.Done = done
+		}
+		data, err := internal.Marshal(&task)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(internal.Itob(ID), data)
+	})
+}
+
 func (service *TaskService) CreateTask(task *pub.Task) error {
 	return service.store.createObject(taskBucketName, task)
 }
@@ -124,6 +146,33 @@ func (service *TaskService) Crons() ([]pub.Cron, error) {
 	return crons, nil
 }
 
+// UpdateCronState applies update to the cron as it is in the store and saves it, in a
+// single transaction, and returns it. update only changes the state the runs keep, the
+// other fields are left as they are in the store.
+func (service *TaskService) UpdateCronState(ID uint64, update func(cron *pub.Cron)) (*pub.Cron, error) {
+	var cron pub.Cron
+	err := service.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(cronBucketName))
+		v := bucket.Get(internal.Itob(ID))
+		if v == nil {
+			return pub.ErrObjNotFound
+		}
+		if err := internal.Unmarshal(v, &cron); err != nil {
+			return err
+		}
+		update(&cron)
+		data, err := internal.Marshal(&cron)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(internal.Itob(ID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cron, nil
+}
+
 func (service *TaskService) UpdateCron(ID uint64, cron *pub.Cron) error {
 	return service.store.updateObjectByID(cronBucketName, ID, cron)
 }
@@ -135,3 +184,87 @@ func (service *TaskService) CreateCron(cron *pub.Cron) error {
 func (service *TaskService) DeleteCron(ID uint64) error {
 	return service.store.deleteObject(cronBucketName, ID)
 }
+
+// CronRuns returns the runs of a cron job, newest first, and the total number of them.
+func (service *TaskService) CronRuns(cronID uint64, offset, limit int) ([]pub.CronRun, int, error) {
+	var (
+		runs  []pub.CronRun
+		total int
+	)
+	err := service.store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(cronRunBucketName))
+		err := eachRun(bucket, cronID, func(k, v []byte) error {
+			if total >= offset && (limit <= 0 || len(runs) < limit) {
+				var run pub.CronRun
+				if err := internal.Unmarshal(v, &run); err != nil {
+					return err
+				}
+				runs = append(runs, run)
+			}
+			total++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if total == 0 {
+			return pub.ErrCronRunEmpty
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return runs, total, nil
+}
+
+func (service *TaskService) CreateCronRun(run *pub.CronRun) error {
+	return service.store.createRun(cronRunBucketName, run.CronID, run)
+}
+
+// PruneCronRuns deletes the runs of a cron job but the newest `keep` ones.
+func (service *TaskService) PruneCronRuns(cronID uint64, keep int) error {
+	return service.store.pruneRuns(cronRunBucketName, cronID, keep)
+}
+
+// TaskRuns returns the runs of a task, newest first, and the total number of them.
+func (service *TaskService) TaskRuns(taskID uint64, offset, limit int) ([]pub.TaskRun, int, error) {
+	var (
+		runs  []pub.TaskRun
+		total int
+	)
+	err := service.store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(taskRunBucketName))
+		err := eachRun(bucket, taskID, func(k, v []byte) error {
+			if total >= offset && (limit <= 0 || len(runs) < limit) {
+				var run pub.TaskRun
+				if err := internal.Unmarshal(v, &run); err != nil {
+					return err
+				}
+				runs = append(runs, run)
+			}
+			total++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if total == 0 {
+			return pub.ErrTaskRunEmpty
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return runs, total, nil
+}
+
+func (service *TaskService) CreateTaskRun(run *pub.TaskRun) error {
+	return service.store.createRun(taskRunBucketName, run.TaskID, run)
+}
+
+// PruneTaskRuns deletes the runs of a task but the newest `keep` ones.
+func (service *TaskService) PruneTaskRuns(taskID uint64, keep int) error {
+	return service.store.pruneRuns(taskRunBucketName, taskID, keep)
+}
@@ -0,0 +1,110 @@
+package bolt
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/api/bolt/internal"
+)
+
+type TemplateService struct {
+	store *Store
+}
+
+func (service *TemplateService) Template(ID uint64) (*pub.TaskTemplate, error) {
+	var tmpl pub.TaskTemplate
+	if err := service.store.getObjectByID(templateBucketName, ID, &tmpl); err != nil {
+		return nil, err
+	}
+	return &tmpl, nil
+}
+
+func (service *TemplateService) TemplateByName(name string) (*pub.TaskTemplate, error) {
+	templates, err := service.store.getObjectByFieldName(templateBucketName, "Name", name)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrTemplateNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return templates[0].(*pub.TaskTemplate), nil
+}
+
+func (service *TemplateService) Templates() ([]pub.TaskTemplate, error) {
+	modelSet, err := service.store.getObjectByFieldName(templateBucketName, "", nil)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrTemplateSetEmpty
+	} else if err != nil {
+		return nil, err
+	}
+	var templates []pub.TaskTemplate
+	for _, m := range modelSet {
+		templates = append(templates, *m.(*pub.TaskTemplate))
+	}
+	return templates, nil
+}
+
+func (service *TemplateService) UpdateTemplate(ID uint64, tmpl *pub.TaskTemplate) error {
+	return service.store.updateObjectByID(templateBucketName, ID, tmpl)
+}
+
+func (service *TemplateService) CreateTemplate(tmpl *pub.TaskTemplate) error {
+	return service.store.createObject(templateBucketName, tmpl)
+}
+
+// DeleteTemplate deletes the template along with its versions.
+func (service *TemplateService) DeleteTemplate(ID uint64) error {
+	if err := service.store.deleteObject(templateBucketName, ID); err != nil {
+		return err
+	}
+	return service.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(templateVersionBucketName))
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var version pub.TemplateVersion
+			if err := internal.Unmarshal(v, &version); err != nil {
+				return err
+			}
+			if version.TemplateID == ID {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err = bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TemplateVersions returns the versions of a template, newest first.
+func (service *TemplateService) TemplateVersions(templateID uint64) ([]pub.TemplateVersion, error) {
+	var versions []pub.TemplateVersion
+	err := service.store.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(templateVersionBucketName)).Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var version pub.TemplateVersion
+			if err := internal.Unmarshal(v, &version); err != nil {
+				return err
+			}
+			if version.TemplateID == templateID {
+				versions = append(versions, version)
+			}
+		}
+		if len(versions) == 0 {
+			return pub.ErrTemplateVersionEmpty
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (service *TemplateService) CreateTemplateVersion(version *pub.TemplateVersion) error {
+	return service.store.createObject(templateVersionBucketName, version)
+}
@@ -0,0 +1,47 @@
+package bolt
+
+import (
+	"github.com/fengxsong/pubmgmt/api"
+)
+
+type WindowService struct {
+	store *Store
+}
+
+func (service *WindowService) Window(ID uint64) (*pub.MaintenanceWindow, error) {
+	var window pub.MaintenanceWindow
+	if err := service.store.getObjectByID(windowBucketName, ID, &window); err != nil {
+		return nil, err
+	}
+	return &window, nil
+}
+
+func (service *WindowService) Windows() ([]pub.MaintenanceWindow, error) {
+	modelSet, err := service.store.getObjectByFieldName(windowBucketName, "", nil)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrWindowSetEmpty
+	} else if err != nil {
+		return nil, err
+	}
+	return trWindows(modelSet), nil
+}
+
+func trWindows(ms []pub.Model) []pub.MaintenanceWindow {
+	var windows []pub.MaintenanceWindow
+	for _, m := range ms {
+		windows = append(windows, *m.(*pub.MaintenanceWindow))
+	}
+	return windows
+}
+
+func (service *WindowService) UpdateWindow(ID uint64, window *pub.MaintenanceWindow) error {
+	return service.store.updateObjectByID(windowBucketName, ID, window)
+}
+
+func (service *WindowService) CreateWindow(window *pub.MaintenanceWindow) error {
+	return service.store.createObject(windowBucketName, window)
+}
+
+func (service *WindowService) DeleteWindow(ID uint64) error {
+	return service.store.deleteObject(windowBucketName, ID)
+}
@@ -0,0 +1,132 @@
+package bolt
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/api/bolt/internal"
+)
+
+type WorkflowService struct {
+	store *Store
+}
+
+func (service *WorkflowService) Workflow(ID uint64) (*pub.Workflow, error) {
+	var workflow pub.Workflow
+	if err := service.store.getObjectByID(workflowBucketName, ID, &workflow); err != nil {
+		return nil, err
+	}
+	return &workflow, nil
+}
+
+func (service *WorkflowService) WorkflowByName(name string) (*pub.Workflow, error) {
+	workflows, err := service.store.getObjectByFieldName(workflowBucketName, "Name", name)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrWorkflowNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return workflows[0].(*pub.Workflow), nil
+}
+
+func (service *WorkflowService) Workflows() ([]pub.Workflow, error) {
+	modelSet, err := service.store.getObjectByFieldName(workflowBucketName, "", nil)
+	if err == pub.ErrModelSetEmpty {
+		return nil, pub.ErrWorkflowSetEmpty
+	} else if err != nil {
+		return nil, err
+	}
+	return trWorkflows(modelSet), nil
+}
+
+func trWorkflows(ms []pub.Model) []pub.Workflow {
+	var workflows []pub.Workflow
+	for _, m := range ms {
+		workflows = append(workflows, *m.(*pub.Workflow))
+	}
+	return workflows
+}
+
+func (service *WorkflowService) UpdateWorkflow(ID uint64, workflow *pub.Workflow) error {
+	return service.store.updateObjectByID(workflowBucketName, ID, workflow)
+}
+
+func (service *WorkflowService) CreateWorkflow(workflow *pub.Workflow) error {
+	return service.store.createObject(workflowBucketName, workflow)
+}
+
+func (service *WorkflowService) DeleteWorkflow(ID uint64) error {
+	return service.store.deleteObject(workflowBucketName, ID)
+}
+
+// WorkflowRuns returns the runs of a workflow, newest first, and the total number of them.
+func (service *WorkflowService) WorkflowRuns(workflowID uint64, offset, limit int) ([]pub.WorkflowRun, int, error) {
+	var (
+		runs  []pub.WorkflowRun
+		total int
+	)
+	err := service.store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(workflowRunBucketName))
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var run pub.WorkflowRun
+			if err := internal.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			if run.WorkflowID != workflowID {
+				continue
+			}
+			if total >= offset && (limit <= 0 || len(runs) < limit) {
+				runs = append(runs, run)
+			}
+			total++
+		}
+		if total == 0 {
+			return pub.ErrWorkflowRunEmpty
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return runs, total, nil
+}
+
+func (service *WorkflowService) CreateWorkflowRun(run *pub.WorkflowRun) error {
+	return service.store.createObject(workflowRunBucketName, run)
+}
+
+func (service *WorkflowService) UpdateWorkflowRun(ID uint64, run *pub.WorkflowRun) error {
+	return service.store.updateObjectByID(workflowRunBucketName, ID, run)
+}
+
+// PruneWorkflowRuns deletes the runs of a workflow but the newest `keep` ones.
+func (service *WorkflowService) PruneWorkflowRuns(workflowID uint64, keep int) error {
+	return service.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(workflowRunBucketName))
+		cursor := bucket.Cursor()
+		var (
+			kept    int
+			expired [][]byte
+		)
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var run pub.WorkflowRun
+			if err := internal.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			if run.WorkflowID != workflowID {
+				continue
+			}
+			if kept < keep {
+				kept++
+				continue
+			}
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -7,17 +7,28 @@ import (
 
 func ParseFlags() (*pub.CliFlags, error) {
 	flags := &pub.CliFlags{
-		Addr:       kingpin.Flag("bind", "address and port to serve pubmgmt").Default(":8080").Short('p').String(),
-		NoAuth:     kingpin.Flag("no-auth", "disable authentication").Default("false").Bool(),
-		ApiPrefix:  kingpin.Flag("api", "path of the api prefix").Default("/api/v0.1").String(),
-		SmtpServer: kingpin.Flag("smtp", "smtp server address and port").Default("smtp.qiye.163.com:25").Short('h').String(),
-		Username:   kingpin.Flag("username", "username of mailer").Default("username@example.com").Short('u').String(),
-		Password:   kingpin.Flag("password", "password of mailer").Default("s3cret").Short('P').String(),
-		FromAlias:  kingpin.Flag("from", "from alias of mailer").Default("pubmgmt").String(),
-		MaxRetry:   kingpin.Flag("retry", "max retry times").Default("3").Int(),
-		QueueSize:  kingpin.Flag("coroutine", "sending mail or task queue size").Default("128").Short('c').Int(),
-		Data:       kingpin.Flag("data", "path to the folder where the data is stored").Default(".").Short('d').String(),
-		Debug:      kingpin.Flag("debug", "turn on/off debug mode").Default("false").Bool(),
+		Addr:              kingpin.Flag("bind", "address and port to serve pubmgmt").Default(":8080").Short('p').String(),
+		NoAuth:            kingpin.Flag("no-auth", "disable authentication").Default("false").Bool(),
+		ApiPrefix:         kingpin.Flag("api", "path of the api prefix").Default("/api/v0.1").String(),
+		SmtpServer:        kingpin.Flag("smtp", "smtp server address and port").Default("smtp.qiye.163.com:25").Short('h').String(),
+		Username:          kingpin.Flag("username", "username of mailer").Default("username@example.com").Short('u').String(),
+		Password:          kingpin.Flag("password", "password of mailer").Default("s3cret").Short('P').String(),
+		FromAlias:         kingpin.Flag("from", "from alias of mailer").Default("pubmgmt").String(),
+		MaxRetry:          kingpin.Flag("retry", "max retry times").Default("3").Int(),
+		QueueSize:         kingpin.Flag("coroutine", "sending mail or task queue size").Default("128").Short('c').Int(),
+		Data:              kingpin.Flag("data", "path to the folder where the data is stored").Default(".").Short('d').String(),
+		Plugins:           kingpin.Flag("plugins", "path to the folder where module plugins are stored").Default("").String(),
+		BecomeRoles:       kingpin.Flag("become-roles", "comma separated roles allowed to run tasks as another user, 1(admin) 2(standard)").Default("1").String(),
+		ApprovalTTL:       kingpin.Flag("approval-expiry", "time a task waits for approval before it expires, 0 never expires").Default("24h").Duration(),
+		MailBackoff:       kingpin.Flag("mail-backoff", "delay before retrying a failed email, doubled on every attempt").Default("30s").Duration(),
+		MailUserRate:      kingpin.Flag("mail-user-rate", "emails a user can send in a minute, 0 is unlimited").Default("10").Int(),
+		MailGlobalRate:    kingpin.Flag("mail-global-rate", "emails all users can send in a minute, 0 is unlimited").Default("60").Int(),
+		MailDailyQuota:    kingpin.Flag("mail-daily-quota", "emails a user can send in a day, 0 is unlimited").Default("200").Int(),
+		MailMaxRecipients: kingpin.Flag("mail-max-recipients", "recipients of an email at most, 0 is unlimited").Default("20").Int(),
+		MailDomains:       kingpin.Flag("mail-domains", "comma separated recipient domains allowed, with their subdomains, empty allows any").Default("").String(),
+		MailRetention:     kingpin.Flag("mail-retention", "time sent emails are kept, a day at least, 0 keeps them forever").Default("720h").Duration(),
+		WorkflowHosts:     kingpin.Flag("workflow-http-hosts", "comma separated hosts the http steps of workflows of non administrators may request, empty allows none").Default("").String(),
+		Debug:             kingpin.Flag("debug", "turn on/off debug mode").Default("false").Bool(),
 	}
 	kingpin.Parse()
 	return flags, nil
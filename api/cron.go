@@ -2,68 +2,219 @@ package pub
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 	"time"
 
 	"github.com/fengxsong/pubmgmt/helper"
 )
 
+const (
+	// DefaultCronRetention is the number of runs kept for a cron without `Retention`.
+	DefaultCronRetention = 100
+	maxRunOutput         = 4096
+)
+
+// Cron is a job scheduled by `Spec` in `Timezone` (an IANA name, the server's
+// timezone when empty), remote crons run `Cmd` on `Hosts` and
+// on every host of `Hostgroups`.
 type Cron struct {
-	ID        uint64    `json:"id"`
-	Name      string    `json:"name" binding:"required"`
-	Type      string    `json:"type" binding:"required"`
-	Cmd       []string  `json:"cmd,omitempty"`
-	URL       string    `json:"url,omitempty"`
-	Spec      string    `json:"spec" binding:"required"`
-	Suspended bool      `json:"suspende"`
-	Running   bool      `json:"running"`
-	Times     int       `json:"times"`
-	Created   time.Time `json:"created"`
-	Updated   time.Time `json:"updated"`
-	Err       error     `json:"-"`
-	Output    string    `json:"-"`
-	fails     int
+	ID         uint64       `json:"id"`
+	Name       string       `json:"name" binding:"required"`
+	Type       string       `json:"type" binding:"required"`
+	Cmd        []string     `json:"cmd,omitempty"`
+	URL        string       `json:"url,omitempty"`
+	Hosts      []string     `json:"hosts,omitempty"`
+	Hostgroups []string     `json:"hostgroups,omitempty"`
+	Spec       string       `json:"spec" binding:"required"`
+	Timezone   string       `json:"timezone,omitempty"`
+	Suspended  bool         `json:"suspende"`
+	Running    bool         `json:"running"`
+	Times      int          `json:"times"`
+	Retry      *RetryPolicy `json:"retry,omitempty"`
+	Retention  int          `json:"retention"`
+	Alert      *CronAlert   `json:"alert,omitempty"`
+	Overlap    string       `json:"overlap,omitempty"`
+	Created    time.Time    `json:"created"`
+	Updated    time.Time    `json:"updated"`
+	Err        error        `json:"-"`
+	Output     string       `json:"-"`
+	// Fails counts the consecutive failed runs, Alerted tells whether
+	// they have been notified already. Both are kept across restarts.
+	Fails   int  `json:"fails"`
+	Alerted bool `json:"alerted"`
+}
+
+// CronAlert defines when the failures of a cron are notified, to the comma
+// separated `Emails` through the mailer and to `Webhook` as a json POST.
+type CronAlert struct {
+	NotifyAfter    int    `json:"notify_after"`
+	NotifyRecovery bool   `json:"notify_recovery"`
+	SuspendAfter   int    `json:"suspend_after"`
+	Emails         string `json:"emails,omitempty"`
+	Webhook        string `json:"webhook,omitempty"`
+}
+
+// CronRun is the record of a single execution of a cron job.
+type CronRun struct {
+	ID         uint64       `json:"id"`
+	CronID     uint64       `json:"cron_id"`
+	Started    time.Time    `json:"started"`
+	Finished   time.Time    `json:"finished"`
+	Skipped    bool         `json:"skipped,omitempty"`
+	Attempts   int          `json:"attempts"`
+	AttemptLog []RunAttempt `json:"attempt_log,omitempty"`
+	ExitStatus int          `json:"exit_status"`
+	StatusCode int          `json:"status_code,omitempty"`
+	Stdout     string       `json:"stdout"`
+	Stderr     string       `json:"stderr"`
+	Err        string       `json:"error,omitempty"`
+	// Hosts keeps the result of every host of a remote cron.
+	Hosts    []CronHostRun   `json:"hosts,omitempty"`
+	Override *WindowOverride `json:"override,omitempty"`
+}
+
+type CronHostRun struct {
+	Host       string `json:"host"`
+	ExitStatus int    `json:"exit_status"`
+	Class      string `json:"class,omitempty"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Err        string `json:"error,omitempty"`
+}
+
+// RemoteRunner executes a remote cron on its hosts over ssh.
+type RemoteRunner interface {
+	RunRemote(c *Cron) ([]CronHostRun, error)
+}
+
+// IsRemote reports whether the cron runs on hosts instead of the pubmgmt server.
+func (c *Cron) IsRemote() bool {
+	t := strings.ToLower(c.Type)
+	return t == "remote" || t == "ssh"
 }
 
 func (*Cron) UniqueFields() []string {
 	return []string{"ID", "Name"}
 }
 
+func (*CronRun) UniqueFields() []string {
+	return []string{"ID"}
+}
+
 func (c *Cron) hasError(err error) {
 	c.Err = err
-	c.fails++
 }
 
 func (c *Cron) hasNotError() {
-	c.Err, c.fails = nil, 0
+	c.Err, c.Fails = nil, 0
 }
 
-func (c *Cron) Run() {
-	if c.Times == 0 {
-		c.Times = 1
+// RetryPolicy returns `Retry`, or a policy retrying any failure up to `Times` attempts.
+func (c *Cron) RetryPolicy() *RetryPolicy {
+	if c.Retry != nil {
+		return c.Retry
 	}
+	return &RetryPolicy{Attempts: c.Times}
+}
+
+// Run executes the job, retrying it by its retry policy, and returns the record of the run.
+// remote is only used by remote crons.
+func (c *Cron) Run(remote RemoteRunner) *CronRun {
 	c.Running = true
 	defer func() { c.Running = false }()
-	for i := 0; i < c.Times; i++ {
-		switch strings.ToLower(c.Type) {
-		case "url", "http":
-			sess := helper.NewSession(nil, nil)
-			resp, err := sess.Get(c.URL, nil, nil)
-			if err != nil {
-				c.hasError(err)
-				continue
-			}
-			c.Output = fmt.Sprintf("Status code: %d, len(response): %d", resp.StatusCode, resp.ContentLength)
-		case "cmd", "command", "shell":
-			stdout, err := helper.Command(c.Cmd[0], c.Cmd[1:]...)
-			if err != nil {
-				c.hasError(err)
-				continue
+	policy := c.RetryPolicy()
+	run := &CronRun{CronID: c.ID, Started: time.Now()}
+	for {
+		run.Attempts++
+		attempt := RunAttempt{Attempt: run.Attempts, Started: time.Now()}
+		class, err := c.attempt(run, remote)
+		attempt.Finished = time.Now()
+		if err == nil {
+			run.AttemptLog = append(run.AttemptLog, attempt)
+			c.hasNotError()
+			run.Err = ""
+			break
+		}
+		attempt.Class, attempt.Err = class, err.Error()
+		run.AttemptLog = append(run.AttemptLog, attempt)
+		c.hasError(err)
+		run.Err = err.Error()
+		if run.Attempts >= policy.MaxAttempts() || !policy.Retryable(class, run.ExitStatus) {
+			break
+		}
+		time.Sleep(policy.Delay(run.Attempts))
+	}
+	if c.Err != nil {
+		c.Fails++
+	}
+	run.Finished = time.Now()
+	c.Updated = run.Finished
+	return run
+}
+
+// attempt runs the job once and returns the failure class along with the error.
+func (c *Cron) attempt(run *CronRun, remote RemoteRunner) (string, error) {
+	switch strings.ToLower(c.Type) {
+	case "remote", "ssh":
+		hosts, err := remote.RunRemote(c)
+		run.Hosts = hosts
+		var (
+			failed int
+			class  string
+		)
+		for i := range hosts {
+			hosts[i].Stdout = helper.Truncate(hosts[i].Stdout, maxRunOutput)
+			hosts[i].Stderr = helper.Truncate(hosts[i].Stderr, maxRunOutput)
+			if hosts[i].Err != "" {
+				failed++
+				// the first failed host decides the class of the whole attempt.
+				if class == "" {
+					class, run.ExitStatus = hosts[i].Class, hosts[i].ExitStatus
+				}
 			}
-			c.Output = stdout
 		}
-		c.hasNotError()
-		break
+		if err != nil {
+			run.ExitStatus = -1
+			return FailureClass(err, -1), err
+		}
+		if failed > 0 {
+			return class, fmt.Errorf("Failed on %d of %d hosts", failed, len(hosts))
+		}
+		run.ExitStatus = 0
+		c.Output = fmt.Sprintf("Succeeded on %d hosts", len(hosts))
+	case "url", "http":
+		sess := helper.NewSession(nil, nil)
+		resp, err := sess.Get(c.URL, nil, nil)
+		if err != nil {
+			run.ExitStatus = -1
+			return FailureClass(err, -1), err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxRunOutput+1))
+		run.StatusCode = resp.StatusCode
+		run.Stdout = helper.Truncate(string(body), maxRunOutput)
+		c.Output = fmt.Sprintf("Status code: %d, len(response): %d", resp.StatusCode, resp.ContentLength)
+		if resp.StatusCode >= 400 {
+			run.ExitStatus = resp.StatusCode
+			return RetryExit, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+		}
+		run.ExitStatus = 0
+	case "cmd", "command", "shell":
+		if len(c.Cmd) == 0 {
+			return RetryOther, fmt.Errorf("Cmd is empty")
+		}
+		stdout, stderr, rc, err := helper.CommandResult(c.Cmd[0], c.Cmd[1:]...)
+		run.ExitStatus = rc
+		run.Stdout = helper.Truncate(stdout, maxRunOutput)
+		run.Stderr = helper.Truncate(stderr, maxRunOutput)
+		if err != nil {
+			return FailureClass(err, rc), err
+		}
+		c.Output = stdout
+	default:
+		return RetryOther, fmt.Errorf("Cron type %s is not supported", c.Type)
 	}
-	c.Updated = time.Now()
+	return "", nil
 }
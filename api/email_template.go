@@ -0,0 +1,89 @@
+package pub
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+)
+
+// Names of the email templates pubmgmt renders its own emails with, a stored
+// template of the same name replaces the built-in one.
+const (
+	TemplateTaskReport       = "task_report"
+	TemplateApprovalRequest  = "approval_request"
+	TemplateApprovalDecision = "approval_decision"
+	TemplateCronAlert        = "cron_alert"
+)
+
+// EmailTemplate renders an email, the subject and the text body with text/template
+// and the html body with html/template, so the values are escaped there.
+type EmailTemplate struct {
+	ID          uint64    `json:"id"`
+	Name        string    `json:"name" binding:"required"`
+	Description string    `json:"description,omitempty"`
+	Subject     string    `json:"subject" binding:"required"`
+	Text        string    `json:"text,omitempty"`
+	HTML        string    `json:"html,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// EmailAttachment is a file attached to an email, `Data` is base64 in JSON.
+type EmailAttachment struct {
+	Filename    string `json:"filename" binding:"required"`
+	ContentType string `json:"content_type,omitempty"`
+	Data        []byte `json:"data" binding:"required"`
+}
+
+func (*EmailTemplate) UniqueFields() []string {
+	return []string{"ID", "Name"}
+}
+
+// Validate parses the parts of the template, one of the bodies at least.
+func (t *EmailTemplate) Validate() error {
+	if t.Text == "" && t.HTML == "" {
+		return Error("Email template requires a text or an html body")
+	}
+	_, _, _, err := t.parse()
+	return err
+}
+
+func (t *EmailTemplate) parse() (subject, text *template.Template, html *htmltemplate.Template, err error) {
+	if subject, err = template.New(t.Name).Option("missingkey=zero").Parse(t.Subject); err != nil {
+		return
+	}
+	if text, err = template.New(t.Name).Option("missingkey=zero").Parse(t.Text); err != nil {
+		return
+	}
+	html, err = htmltemplate.New(t.Name).Option("missingkey=zero").Parse(t.HTML)
+	return
+}
+
+// Render fills the template with data, into the subject and bodies of the email.
+func (t *EmailTemplate) Render(data interface{}, email *Email) error {
+	subject, text, html, err := t.parse()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = subject.Execute(&buf, data); err != nil {
+		return err
+	}
+	email.Subject = buf.String()
+	if t.Text != "" {
+		buf.Reset()
+		if err = text.Execute(&buf, data); err != nil {
+			return err
+		}
+		email.Content = buf.String()
+	}
+	if t.HTML != "" {
+		buf.Reset()
+		if err = html.Execute(&buf, data); err != nil {
+			return err
+		}
+		email.HTML = buf.String()
+	}
+	return nil
+}
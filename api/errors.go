@@ -40,8 +40,22 @@ const (
 
 // Email errors
 const (
-	ErrEmailNotFound    = Error("Email not found")
-	ErrEmailOutboxEmpty = Error("Not any emails in outbox")
+	ErrEmailNotFound              = Error("Email not found")
+	ErrEmailOutboxEmpty           = Error("Not any emails in outbox")
+	ErrEmailSending               = Error("Email is being sent")
+	ErrEmailSent                  = Error("Email has been sent")
+	ErrEmailTemplateNotFound      = Error("Email template not found")
+	ErrEmailTemplateSetEmpty      = Error("Not any email templates yet")
+	ErrEmailTemplateAlreadyExists = Error("Email template already exists")
+	ErrSMTPProfileNotFound        = Error("SMTP profile not found")
+	ErrSMTPProfileSetEmpty        = Error("Not any SMTP profiles yet")
+	ErrSMTPProfileAlreadyExists   = Error("SMTP profile already exists")
+	ErrSMTPProfileInUse           = Error("SMTP profile is used by queued emails")
+	ErrMailRateLimited            = Error("Too many emails sent, try again later")
+	ErrMailQuotaExceeded          = Error("Daily email quota exceeded")
+	ErrTooManyRecipients          = Error("Too many recipients")
+	ErrNoRecipients               = Error("Email requires recipients")
+	ErrAttachmentsTooLarge        = Error("Email attachments are too large")
 )
 
 // Task errors
@@ -50,6 +64,52 @@ const (
 	ErrTaskSetEmpty = Error("Not any tasks yet")
 	ErrCronNotFound = Error("Cron job not found")
 	ErrCronSetEmpty = Error("Not any cron jobs yet")
+	ErrCronRunEmpty = Error("Not any runs of the cron job yet")
+	ErrTaskRunEmpty = Error("Not any runs of the task yet")
+	ErrOverlap      = Error("Overlap must be allow, skip or queue")
+	ErrBecomeDenied = Error("Running as another user is not allowed for current role")
+)
+
+// Policy errors
+const (
+	ErrPolicyNotFound      = Error("Policy not found")
+	ErrPolicySetEmpty      = Error("Not any policies yet")
+	ErrPolicyAlreadyExists = Error("Policy already exists")
+)
+
+// Workflow errors
+const (
+	ErrWorkflowNotFound      = Error("Workflow not found")
+	ErrWorkflowSetEmpty      = Error("Not any workflows yet")
+	ErrWorkflowAlreadyExists = Error("Workflow already exists")
+	ErrWorkflowRunEmpty      = Error("Not any runs of the workflow yet")
+	ErrHTTPStepDenied        = Error("Only administrators can request this url in http steps")
+)
+
+// Approval errors
+const (
+	ErrApprovalDenied  = Error("Not an approver of the task")
+	ErrSelfApproval    = Error("Approving or rejecting your own task is not allowed")
+	ErrAlreadyDecided  = Error("You have decided on the task already")
+	ErrApprovalExpired = Error("Task approval has expired")
+	ErrNotPending      = Error("Task is not waiting for approval")
+	ErrNotApproved     = Error("Task is waiting for approval or has not been approved")
+)
+
+// Task template errors
+const (
+	ErrTemplateNotFound      = Error("Task template not found")
+	ErrTemplateSetEmpty      = Error("Not any task templates yet")
+	ErrTemplateAlreadyExists = Error("Task template already exists")
+	ErrTemplateVersionEmpty  = Error("Not any versions of the task template yet")
+)
+
+// Maintenance window errors
+const (
+	ErrWindowNotFound      = Error("Maintenance window not found")
+	ErrWindowSetEmpty      = Error("Not any maintenance windows yet")
+	ErrWindowAlreadyExists = Error("Maintenance window already exists")
+	ErrOverrideDenied      = Error("Only administrators can override maintenance windows")
 )
 
 // Modules errors
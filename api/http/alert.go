@@ -0,0 +1,117 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/helper"
+)
+
+const (
+	alertFailure   = "failure"
+	alertRecovery  = "recovery"
+	alertSuspended = "suspended"
+)
+
+// alertEvent is the body posted to the webhook of a cron alert.
+type alertEvent struct {
+	Cron  string    `json:"cron"`
+	ID    uint64    `json:"id"`
+	Event string    `json:"event"`
+	Fails int       `json:"fails"`
+	Err   string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// cronAlerts counts the run of the cron, whose error is in `Err`, among its failures and
+// returns the alerts it raises. It updates the state of the alerts and suspends the cron.
+func cronAlerts(c *pub.Cron) []string {
+	if c.Err == nil {
+		c.Fails = 0
+	} else {
+		c.Fails++
+	}
+	a := c.Alert
+	if a == nil {
+		return nil
+	}
+	var events []string
+	if c.Err == nil {
+		if c.Alerted {
+			c.Alerted = false
+			if a.NotifyRecovery {
+				events = append(events, alertRecovery)
+			}
+		}
+		return events
+	}
+	if a.NotifyAfter > 0 && c.Fails >= a.NotifyAfter && !c.Alerted {
+		c.Alerted = true
+		events = append(events, alertFailure)
+	}
+	if a.SuspendAfter > 0 && c.Fails >= a.SuspendAfter && !c.Suspended {
+		c.Suspended = true
+		events = append(events, alertSuspended)
+	}
+	return events
+}
+
+// finishCron saves the state of the run made on c, a copy of the cron, into the cron as it
+// is stored now, so the edits made during the run are kept, and sends the alerts it raises.
+func (t *TaskHandler) finishCron(c *pub.Cron) {
+	var events []string
+	stored, err := t.TaskService.UpdateCronState(c.ID, func(s *pub.Cron) {
+		s.Err = c.Err
+		s.Updated = c.Updated
+		events = cronAlerts(s)
+	})
+	if err != nil {
+		Errorf(t.Logger, "Cron %s, error when saving its state: %s", c.Name, err)
+		return
+	}
+	stored.Err = c.Err
+	for _, event := range events {
+		t.sendAlert(stored, event)
+	}
+	if stored.Suspended {
+		t.scheduler.Remove(cronKey(c.ID))
+	}
+}
+
+func (t *TaskHandler) sendAlert(c *pub.Cron, event string) {
+	evt := &alertEvent{Cron: c.Name, ID: c.ID, Event: event, Fails: c.Fails, Time: time.Now()}
+	if c.Err != nil {
+		evt.Err = c.Err.Error()
+	}
+	if c.Alert.Emails != "" && t.Mailer != nil {
+		t.Mailer.Notify(pub.TemplateCronAlert, evt, c.Alert.Emails)
+	}
+	if c.Alert.Webhook != "" {
+		go func() {
+			if err := postWebhook(c.Alert.Webhook, evt); err != nil {
+				Errorf(t.Logger, "Cron %s, error when posting alert to webhook: %s", c.Name, err)
+			}
+		}()
+	}
+}
+
+func postWebhook(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	headers := &http.Header{}
+	headers.Set("Content-Type", "application/json")
+	resp, err := helper.NewSession(nil, nil).Post(url, nil, headers, &body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// approvalSweep is how often the pending approvals are checked for expiry.
+const approvalSweep = time.Minute
+
+// awaitingApproval reports whether the task is still waiting for approval,
+// tasks stored before the approval history have no Approval.
+func awaitingApproval(task *pub.Task) bool {
+	if !task.RequiredApproval {
+		return false
+	}
+	if task.Approval == nil {
+		return task.Done.IsZero()
+	}
+	return task.Approval.Status == pub.ApprovalPending
+}
+
+// notApproved reports whether the task may not run, waiting for approval or refused it,
+// or a one-shot task which ran already on its approval.
+func notApproved(task *pub.Task) bool {
+	if awaitingApproval(task) || ranApproved(task) {
+		return true
+	}
+	return task.Approval != nil && (task.Approval.Status == pub.ApprovalRejected || task.Approval.Status == pub.ApprovalExpired)
+}
+
+// ranApproved reports whether the approved one-shot task ran already, its approval
+// does not hold for another run.
+func ranApproved(task *pub.Task) bool {
+	return task.Spec == "" && task.Approval != nil && task.Approval.Status == pub.ApprovalApproved && task.Status.Finished()
+}
+
+// renewApproval starts a new pending approval of the task, the decisions taken so far
+// do not count anymore.
+func renewApproval(task *pub.Task, at time.Time, defaultExpiry time.Duration) {
+	if task.Approval == nil {
+		task.Approval = pub.NewApproval(pub.ApprovalPolicy{}, at, defaultExpiry)
+	} else {
+		task.Approval = task.Approval.Renew(at, defaultExpiry)
+	}
+	task.Status = pub.TaskPendingApproval
+}
+
+// reapprove renews the approval of a one-shot task which ran already, the approvers are
+// asked again to run it once more. The response has been written when it returns.
+func (t *TaskHandler) reapprove(ctx *gin.Context, id uint64) {
+	t.approvals.Lock()
+	task, err := t.TaskService.Task(id)
+	if err == nil && ranApproved(task) {
+		renewApproval(task, time.Now(), t.approvalTTL)
+		err = t.TaskService.UpdateTask(task.ID, task)
+	} else if err == nil {
+		err = pub.ErrNotApproved
+	}
+	t.approvals.Unlock()
+	if err == pub.ErrNotApproved {
+		Error(ctx, err, http.StatusConflict, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	go t.notifyApprovers(task, ctx.Request.Host)
+	ctx.IndentedJSON(http.StatusAccepted, &msgResponse{Msg: fmt.Sprintf("Task requires a new approval to run again, check %s/tasks/detail/%d/approvals", ctx.Request.Host, task.ID)})
+}
+
+// approvalRequest is the body of an approval or a rejection, `Override` is the reason
+// of an administrator to run regardless of the maintenance windows once approved.
+type approvalRequest struct {
+	Comment  string `json:"comment"`
+	Override string `json:"override"`
+}
+
+// url: /tasks/detail/:id/approve  method: POST  body: approvalRequest
+func (t *TaskHandler) approveTaskByID(ctx *gin.Context) {
+	t.decideByID(ctx, pub.DecisionApprove)
+}
+
+// url: /tasks/detail/:id/reject  method: POST  body: approvalRequest
+func (t *TaskHandler) rejectTaskByID(ctx *gin.Context) {
+	t.decideByID(ctx, pub.DecisionReject)
+}
+
+func (t *TaskHandler) decideByID(ctx *gin.Context, decision string) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	var req approvalRequest
+	if err = ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	// the task is loaded under the lock, so that decisions on it go one after another.
+	t.approvals.Lock()
+	defer t.approvals.Unlock()
+	task, err := t.TaskService.Task(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrTaskNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if !awaitingApproval(task) {
+		Error(ctx, pub.ErrNotPending, http.StatusConflict, nil)
+		return
+	}
+	t.decide(ctx, tokenData, task, decision, req.Comment, req.Override)
+}
+
+// decide records the decision of the user on a pending task, under the approvals lock.
+// An approved task is scheduled or run right away.
+func (t *TaskHandler) decide(ctx *gin.Context, tokenData *pub.TokenData, task *pub.Task, decision, comment, override string) {
+	if task.Approval == nil {
+		task.Approval = pub.NewApproval(pub.ApprovalPolicy{}, task.Created, t.approvalTTL)
+	}
+	approval := *task.Approval
+	approval.History = append([]pub.ApprovalDecision(nil), task.Approval.History...)
+	err := approval.Decide(task.RequiredUserID, tokenData.Role, pub.ApprovalDecision{
+		UserID:   tokenData.ID,
+		Username: tokenData.Username,
+		Decision: decision,
+		Comment:  comment,
+		Time:     time.Now(),
+	})
+	switch err {
+	case nil:
+	case pub.ErrApprovalExpired:
+		t.expireApproval(task)
+		Error(ctx, err, http.StatusGone, nil)
+		return
+	case pub.ErrSelfApproval, pub.ErrApprovalDenied:
+		Error(ctx, err, http.StatusForbidden, nil)
+		return
+	default:
+		Error(ctx, err, http.StatusConflict, nil)
+		return
+	}
+	if approval.Status == pub.ApprovalApproved && task.Spec == "" {
+		// the decision is not recorded when the maintenance windows refuse to run the task.
+		var o *pub.WindowOverride
+		if o, err = t.checkWindows(ctx, task.Hosts, override); err != nil {
+			return
+		}
+		addOverride(task, o)
+	}
+	task.Approval = &approval
+	switch approval.Status {
+	case pub.ApprovalApproved:
+		task.Status = pub.TaskQueued
+	case pub.ApprovalRejected:
+		task.Status = pub.TaskCancelled
+	}
+	if err = t.TaskService.UpdateTask(task.ID, task); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	switch approval.Status {
+	case pub.ApprovalRejected:
+		go t.notifyOwner(task, "rejected by "+tokenData.Username, comment)
+		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Task rejected"})
+	case pub.ApprovalApproved:
+		if task.Spec != "" {
+			if err = t.scheduleTask(task); err != nil {
+				Error(ctx, err, http.StatusBadRequest, nil)
+				return
+			}
+		} else {
+			t.incoming <- task
+		}
+		go t.notifyOwner(task, "approved", "")
+		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: fmt.Sprintf("Task approved and will execute very soon, check %s/tasks/events/%s for detail later", ctx.Request.Host, eventPrefix+task.UUID)})
+	default:
+		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: fmt.Sprintf("Task approved, %d of %d approvals", approval.Approvals(), approval.Policy.RequiredApprovals())})
+	}
+}
+
+// url: /tasks/detail/:id/approvals  method: GET
+func (t *TaskHandler) getTaskApprovalsByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	task, err := t.TaskService.Task(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrTaskNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if task.Approval == nil {
+		Error(ctx, pub.ErrNotPending, http.StatusNotFound, nil)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, task.Approval)
+}
+
+// expireApprovals expires the pending approvals past their expiry in backgroud.
+func (t *TaskHandler) expireApprovals() {
+	for range time.Tick(approvalSweep) {
+		tasks, err := t.TaskService.Tasks(&pub.TaskFilter{RequiredApproval: true})
+		if err != nil {
+			if err != pub.ErrTaskSetEmpty {
+				Errorf(t.Logger, "Error when getting tasks require approval: %s", err)
+			}
+			continue
+		}
+		now := time.Now()
+		for i := range tasks {
+			if !awaitingApproval(&tasks[i]) || tasks[i].Approval == nil || !tasks[i].Approval.Expired(now) {
+				continue
+			}
+			t.approvals.Lock()
+			// a decision may have come in since the tasks were read.
+			if task, err := t.TaskService.Task(tasks[i].ID); err == nil && task.Approval != nil && task.Approval.Expired(now) {
+				t.expireApproval(task)
+			}
+			t.approvals.Unlock()
+		}
+	}
+}
+
+// expireApproval expires the approval of the task, under the approvals lock.
+func (t *TaskHandler) expireApproval(task *pub.Task) {
+	if err := task.Approval.Transition(pub.ApprovalExpired, time.Now()); err != nil {
+		Errorf(t.Logger, "Task %s: %s", task.Name, err)
+		return
+	}
+	task.Status = pub.TaskCancelled
+	if err := t.TaskService.UpdateTask(task.ID, task); err != nil {
+		Errorf(t.Logger, "Task %s, error when expiring approval: %s", task.Name, err)
+	}
+	Infof(t.Logger, "Task %s, approval expired", task.Name)
+	go t.notifyOwner(task, "expired before it was approved", "")
+}
+
+// approverEmails returns the email addresses of the approvers of the task, but its owner.
+func (t *TaskHandler) approverEmails(task *pub.Task) ([]string, error) {
+	policy := task.Approval.Policy
+	roles := policy.Roles
+	if len(roles) == 0 && len(policy.Approvers) == 0 {
+		roles = []pub.UserRole{pub.AdministratorRole}
+	}
+	var users []pub.User
+	for _, id := range policy.Approvers {
+		user, err := t.Mailer.UserService.User(id)
+		if err == pub.ErrObjNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	for _, role := range roles {
+		members, err := t.Mailer.UserService.UsersByRole(role)
+		if err != nil && err != pub.ErrModelSetEmpty {
+			return nil, err
+		}
+		users = append(users, members...)
+	}
+	var emails []string
+	seen := make(map[uint64]bool)
+	for _, user := range users {
+		if seen[user.ID] || user.ID == task.RequiredUserID || user.Email == "" || !user.IsActive {
+			continue
+		}
+		seen[user.ID] = true
+		emails = append(emails, user.Email)
+	}
+	return emails, nil
+}
+
+// notifyApprovers mails the approvers of a task waiting for approval.
+func (t *TaskHandler) notifyApprovers(task *pub.Task, host string) {
+	if t.Mailer == nil {
+		return
+	}
+	emails, err := t.approverEmails(task)
+	if err != nil {
+		Errorf(t.Logger, "Task %s, error when getting approvers: %s", task.Name, err)
+		return
+	}
+	if len(emails) == 0 {
+		Infof(t.Logger, "Task %s, no approver to notify", task.Name)
+		return
+	}
+	var commands []string
+	for _, cmd := range task.Strings() {
+		commands = append(commands, strings.Join(cmd, " "))
+	}
+	expires := "never"
+	if !task.Approval.Expires.IsZero() {
+		expires = task.Approval.Expires.Format(time.RFC3339)
+	}
+	url := fmt.Sprintf("%s/tasks/detail/%d", host, task.ID)
+	data := struct {
+		Task                  *pub.Task
+		Commands              []string
+		Required              int
+		Expires               string
+		ApproveURL, RejectURL string
+	}{task, commands, task.Approval.Policy.RequiredApprovals(), expires, url + "/approve", url + "/reject"}
+	t.Mailer.Notify(pub.TemplateApprovalRequest, data, strings.Join(emails, ","))
+}
+
+// notifyOwner mails the owner of a task about the decision on its approval.
+func (t *TaskHandler) notifyOwner(task *pub.Task, decision, comment string) {
+	if t.Mailer == nil {
+		return
+	}
+	owner, err := t.Mailer.UserService.User(task.RequiredUserID)
+	if err != nil || owner.Email == "" {
+		return
+	}
+	data := struct {
+		Task              *pub.Task
+		Decision, Comment string
+		Time              time.Time
+	}{task, decision, comment, time.Now()}
+	t.Mailer.Notify(pub.TemplateApprovalDecision, data, owner.Email)
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// taskStore keeps the tasks in memory, the other methods of the service are not implemented.
+type taskStore struct {
+	pub.TaskService
+	tasks map[uint64]pub.Task
+}
+
+func (s *taskStore) Task(ID uint64) (*pub.Task, error) {
+	task, ok := s.tasks[ID]
+	if !ok {
+		return nil, pub.ErrObjNotFound
+	}
+	return &task, nil
+}
+
+func (s *taskStore) UpdateTask(ID uint64, task *pub.Task) error {
+	s.tasks[ID] = *task
+	return nil
+}
+
+func TestApprovalStates(t *testing.T) {
+	now := time.Now()
+	approval := func(status string) *pub.Approval {
+		return &pub.Approval{Status: status, Updated: now}
+	}
+	for _, c := range []struct {
+		name                        string
+		task                        pub.Task
+		awaiting, notApproved, rans bool
+	}{
+		{"no approval required", pub.Task{}, false, false, false},
+		{"pending", pub.Task{RequiredApproval: true, Approval: approval(pub.ApprovalPending)}, true, true, false},
+		{"legacy pending", pub.Task{RequiredApproval: true}, true, true, false},
+		{"legacy done", pub.Task{RequiredApproval: true, Done: now}, false, false, false},
+		{"approved", pub.Task{RequiredApproval: true, Approval: approval(pub.ApprovalApproved), Status: pub.TaskQueued}, false, false, false},
+		{"rejected", pub.Task{RequiredApproval: true, Approval: approval(pub.ApprovalRejected)}, false, true, false},
+		{"expired", pub.Task{RequiredApproval: true, Approval: approval(pub.ApprovalExpired)}, false, true, false},
+		{"one-shot ran", pub.Task{RequiredApproval: true, Approval: approval(pub.ApprovalApproved), Status: pub.TaskSucceeded}, false, true, true},
+		{"scheduled ran", pub.Task{RequiredApproval: true, Spec: "@every 1h", Approval: approval(pub.ApprovalApproved), Status: pub.TaskSucceeded}, false, false, false},
+	} {
+		task := c.task
+		if got := awaitingApproval(&task); got != c.awaiting {
+			t.Errorf("%s: awaitingApproval = %v, want %v", c.name, got, c.awaiting)
+		}
+		if got := notApproved(&task); got != c.notApproved {
+			t.Errorf("%s: notApproved = %v, want %v", c.name, got, c.notApproved)
+		}
+		if got := ranApproved(&task); got != c.rans {
+			t.Errorf("%s: ranApproved = %v, want %v", c.name, got, c.rans)
+		}
+	}
+}
+
+// postAs posts the body to the handler with the token data of the user.
+func postAs(user *pub.TokenData, pattern, path, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST(pattern, func(ctx *gin.Context) { ctx.Set(contextAuthenticationKey, user) }, handler)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestApprovalTransitions(t *testing.T) {
+	var (
+		owner    = &pub.TokenData{ID: 2, Username: "owner", Role: pub.StandardUserRole}
+		other    = &pub.TokenData{ID: 3, Username: "other", Role: pub.StandardUserRole}
+		approver = &pub.TokenData{ID: 1, Username: "admin", Role: pub.AdministratorRole}
+	)
+	store := &taskStore{tasks: map[uint64]pub.Task{1: {
+		ID:               1,
+		Name:             "backup",
+		RequiredUserID:   owner.ID,
+		RequiredApproval: true,
+		Spec:             "@every 1h",
+		Status:           pub.TaskPendingApproval,
+		Approval:         pub.NewApproval(pub.ApprovalPolicy{}, time.Now(), 0),
+	}}}
+	th := &TaskHandler{TaskService: store, scheduler: newScheduler()}
+	approve := func(user *pub.TokenData) int {
+		return postAs(user, "/tasks/detail/:id/approve", "/tasks/detail/1/approve", `{}`, th.approveTaskByID).Code
+	}
+	modify := func(user *pub.TokenData, body string) int {
+		return postAs(user, "/tasks/detail/:id", "/tasks/detail/1", body, th.modifyTaskByID).Code
+	}
+	expect := func(step string, code, wantCode int, status string, scheduled bool) {
+		t.Helper()
+		task := store.tasks[1]
+		entry := th.scheduler.Entry(taskKey(1)) != nil
+		if code != wantCode || task.Approval.Status != status || entry != scheduled {
+			t.Errorf("%s: code %d, approval %s, scheduled %v, want %d, %s, %v", step, code, task.Approval.Status, entry, wantCode, status, scheduled)
+		}
+	}
+
+	expect("self approval", approve(owner), http.StatusForbidden, pub.ApprovalPending, false)
+	expect("approval", approve(approver), http.StatusOK, pub.ApprovalApproved, true)
+	expect("modified by another user", modify(other, `{"id": 1, "spec": "@every 1m"}`), http.StatusForbidden, pub.ApprovalApproved, true)
+	expect("suspended", modify(owner, `{"id": 1, "spec": "@every 1h", "suspended": true}`), http.StatusOK, pub.ApprovalApproved, false)
+	expect("resumed", modify(owner, `{"id": 1, "spec": "@every 1h"}`), http.StatusOK, pub.ApprovalApproved, true)
+	expect("spec changed", modify(owner, `{"id": 1, "spec": "@every 1m"}`), http.StatusAccepted, pub.ApprovalPending, false)
+	if task := store.tasks[1]; len(task.Approval.History) != 0 || len(task.Approval.Previous) != 1 || task.Status != pub.TaskPendingApproval {
+		t.Errorf("spec changed: history %v, previous %v, status %s, want the decision moved to previous", task.Approval.History, task.Approval.Previous, task.Status)
+	}
+	expect("overlap changed while pending", modify(owner, `{"id": 1, "spec": "@every 1m", "overlap": "allow"}`), http.StatusOK, pub.ApprovalPending, false)
+	expect("timezone changed while pending", modify(approver, `{"id": 1, "spec": "@every 1m", "timezone": "UTC"}`), http.StatusAccepted, pub.ApprovalPending, false)
+	expect("re-approval", approve(approver), http.StatusOK, pub.ApprovalApproved, true)
+	if task := store.tasks[1]; task.Spec != "@every 1m" || task.Timezone != "UTC" || task.Status != pub.TaskQueued {
+		t.Errorf("re-approval: spec %s, timezone %s, status %s", task.Spec, task.Timezone, task.Status)
+	}
+}
@@ -117,6 +117,7 @@ func (h *HostHandler) createHost(ctx *gin.Context) {
 	}
 	reqHost.HostgroupID = req.HostgroupID
 	reqHost.Password = req.Password
+	reqHost.BecomePassword = req.BecomePassword
 	reqHost.IdentityFile = req.IdentityFile
 	reqHost.Comment = req.Comment
 	reqHost.IsActive = req.IsActive
@@ -130,15 +131,17 @@ func (h *HostHandler) createHost(ctx *gin.Context) {
 
 // for creating a host from specical json format
 type putHostRequest struct {
-	Format       string `json:"format" binding:"required"` // "root@localhost:22"
-	Password     string `json:"password"`
-	HostgroupID  uint64 `json:"hostgroup_id"`
-	IdentityFile string `json:"identity_file"`
-	Comment      string `json:"comment"`
-	IsActive     bool   `json:"is_active"`
+	Format         string `json:"format" binding:"required"` // "root@localhost:22"
+	Password       string `json:"password"`
+	BecomePassword string `json:"become_password"`
+	HostgroupID    uint64 `json:"hostgroup_id"`
+	IdentityFile   string `json:"identity_file"`
+	Comment        string `json:"comment"`
+	IsActive       bool   `json:"is_active"`
 }
 
 // url: /hosts  method: GET
+// passwords are never returned.
 func (h *HostHandler) getHosts(ctx *gin.Context) {
 	hosts, err := h.HostService.Hosts()
 	if err == pub.ErrHostSetEmpty {
@@ -146,11 +149,15 @@ func (h *HostHandler) getHosts(ctx *gin.Context) {
 	} else if err != nil {
 		Error(ctx, err, http.StatusInternalServerError, h.Logger)
 	} else {
+		for i := range hosts {
+			hosts[i].ClearSecrets()
+		}
 		ctx.IndentedJSON(http.StatusOK, hosts)
 	}
 }
 
 // url: /hosts/pk/:id  method: GET
+// passwords are never returned.
 func (h *HostHandler) getHostByID(ctx *gin.Context) {
 	ID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
@@ -167,6 +174,7 @@ func (h *HostHandler) getHostByID(ctx *gin.Context) {
 		Error(ctx, err, http.StatusInternalServerError, h.Logger)
 		return
 	}
+	host.ClearSecrets()
 	ctx.IndentedJSON(http.StatusOK, host)
 }
 
@@ -187,6 +195,9 @@ func (h *HostHandler) updateHostByID(ctx *gin.Context) {
 	if req.Password != "" {
 		host.Password = req.Password
 	}
+	if req.BecomePassword != "" {
+		host.BecomePassword = req.BecomePassword
+	}
 	if req.HostgroupID != 0 {
 		host.HostgroupID = req.HostgroupID
 	}
@@ -206,13 +217,14 @@ func (h *HostHandler) updateHostByID(ctx *gin.Context) {
 }
 
 type postHostRequest struct {
-	ID           uint64
-	Hostname     string `json:"hostname"`
-	Password     string `json:"password,omitempty"`
-	HostgroupID  uint64 `json:"hostgroup_id"`
-	IdentityFile string `json:"identity_file,omitempty"`
-	Comment      string `json:"comment"`
-	IsActive     bool   `json:"is_active"`
+	ID             uint64
+	Hostname       string `json:"hostname"`
+	Password       string `json:"password,omitempty"`
+	BecomePassword string `json:"become_password,omitempty"`
+	HostgroupID    uint64 `json:"hostgroup_id"`
+	IdentityFile   string `json:"identity_file,omitempty"`
+	Comment        string `json:"comment"`
+	IsActive       bool   `json:"is_active"`
 }
 
 func (h *HostHandler) _getHostByID(ctx *gin.Context) *pub.Host {
@@ -18,7 +18,9 @@ const (
 	ErrInvalidQueryFormat = pub.Error("Invalid query format")
 	// ErrEmptyResponseBody defines an error raised when portainer excepts to parse the body of a HTTP response and there is nothing to parse
 	ErrEmptyResponseBody = pub.Error("Empty response body")
-	errIDField           = pub.Error("ID field must equal to ID param in request uri")
+	// ErrJSONRequired defines an error raised when an action is requested without a JSON content type
+	ErrJSONRequired = pub.Error("Content-Type must be application/json")
+	errIDField      = pub.Error("ID field must equal to ID param in request uri")
 )
 
 type logger *log.Logger
@@ -61,6 +63,27 @@ type msgResponse struct {
 	Msg string `json:"msg,omitempty"`
 }
 
+// pageResponse is a generic response for sending a page of items.
+type pageResponse struct {
+	Total int         `json:"total"`
+	Page  int         `json:"page"`
+	Size  int         `json:"size"`
+	Items interface{} `json:"items"`
+}
+
+const maxPageSize = 100
+
+// getPagination reads `page` (from 1) and `size` from the query.
+func getPagination(ctx *gin.Context) (page, size int, err error) {
+	if page, err = strconv.Atoi(ctx.DefaultQuery("page", "1")); err != nil || page < 1 {
+		return 0, 0, ErrInvalidQueryFormat
+	}
+	if size, err = strconv.Atoi(ctx.DefaultQuery("size", "20")); err != nil || size < 1 || size > maxPageSize {
+		return 0, 0, ErrInvalidQueryFormat
+	}
+	return page, size, nil
+}
+
 func getID(ctx *gin.Context) uint64 {
 	ID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
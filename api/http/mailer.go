@@ -5,10 +5,11 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fengxsong/pubmgmt/api"
-	"github.com/kataras/go-mailer"
+	"github.com/fengxsong/pubmgmt/helper"
 	"gopkg.in/gin-gonic/gin.v1"
 )
 
@@ -16,14 +17,31 @@ type MailerHandler struct {
 	Logger        logger
 	UserService   pub.UserService
 	MailerService pub.MailerService
-	defaultSender mailer.Service
-	incoming      chan *pub.Email
-	processing    chan *pub.Email
+	defaultSMTP   helper.SMTPConfig
+	wake          chan struct{}
 	mq            chan struct{}
 	retry         int
+	backoff       time.Duration
+	retention     time.Duration
+	// profiles limits the emails sent through each smtp profile.
+	profiles *rateLimiter
+	policy   *mailPolicy
+	// queue guards the status of the emails between the dispatcher and the admin.
+	queue sync.Mutex
 }
 
-func newMailerHandler(u pub.UserService, m pub.MailerService, flags *pub.CliFlags) *MailerHandler {
+const (
+	// mailPollInterval is how often the queue is checked for emails due for a retry.
+	mailPollInterval = 10 * time.Second
+	// maxMailBackoff bounds the delay between two attempts.
+	maxMailBackoff = time.Hour
+	// mailPruneInterval is how often the emails sent before the retention are deleted.
+	mailPruneInterval = time.Hour
+	// minMailRetention keeps the emails of the day, the daily quotas count them.
+	minMailRetention = 24 * time.Hour
+)
+
+func newMailerHandler(l logger, u pub.UserService, m pub.MailerService, flags *pub.CliFlags) *MailerHandler {
 	smtpServer := strings.Split(*flags.SmtpServer, ":")
 	var smtpPort int
 	if len(smtpServer) != 2 {
@@ -33,66 +51,325 @@ func newMailerHandler(u pub.UserService, m pub.MailerService, flags *pub.CliFlag
 	}
 
 	mailer := &MailerHandler{
+		Logger:        l,
 		UserService:   u,
 		MailerService: m,
-		defaultSender: mailer.New(
-			mailer.Config{
-				Host:      smtpServer[0],
-				Port:      smtpPort,
-				Username:  *flags.Username,
-				Password:  *flags.Password,
-				FromAlias: *flags.FromAlias,
-			}),
-		incoming:   make(chan *pub.Email, 1<<8),
-		processing: make(chan *pub.Email, 1<<8),
-		mq:         make(chan struct{}, *flags.QueueSize),
-		retry:      *flags.MaxRetry,
-	}
-	go mailer.pickup()
-	go mailer.sendQueue()
+		defaultSMTP: helper.SMTPConfig{
+			Host:      smtpServer[0],
+			Port:      smtpPort,
+			Auth:      helper.AuthNone,
+			Username:  *flags.Username,
+			Password:  *flags.Password,
+			FromAlias: *flags.FromAlias,
+		},
+		wake:      make(chan struct{}, 1),
+		mq:        make(chan struct{}, *flags.QueueSize),
+		retry:     *flags.MaxRetry,
+		backoff:   *flags.MailBackoff,
+		retention: *flags.MailRetention,
+		profiles:  newRateLimiter(time.Minute),
+		policy:    newMailPolicy(flags),
+	}
+	if *flags.Password != "" {
+		mailer.defaultSMTP.Auth = helper.AuthPlain
+	}
+	if mailer.retry < 1 {
+		mailer.retry = 1
+	}
+	if mailer.retention > 0 && mailer.retention < minMailRetention {
+		mailer.retention = minMailRetention
+	}
+	mailer.scrubConfigs()
+	mailer.recoverQueue()
+	go mailer.dispatch()
 	return mailer
 }
 
-// pickup email from channel m.incoming, and then send it to m.processing
-func (m *MailerHandler) pickup() {
+// scrubConfigs removes the smtp credentials the emails were stored with before smtp
+// profiles, once rewritten an email is not scrubbed again.
+func (m *MailerHandler) scrubConfigs() {
+	n, err := m.MailerService.ScrubEmailConfigs()
+	if err != nil {
+		Errorf(m.Logger, "Error when scrubbing smtp configs from stored emails: %s", err)
+	} else if n > 0 {
+		Infof(m.Logger, "%d emails scrubbed of their smtp config", n)
+	}
+}
+
+// recoverQueue queues again the emails which were being sent when pubmgmt stopped,
+// they may be delivered twice. The waiting emails are saved again to index them in
+// the mail queue, the emails stored before it had one are not.
+func (m *MailerHandler) recoverQueue() {
+	emails, err := m.MailerService.Emails(&pub.EmailFilter{Status: []pub.EmailStatus{pub.EmailSending, pub.EmailQueued, pub.EmailFailed}})
+	if err == pub.ErrEmailOutboxEmpty {
+		return
+	} else if err != nil {
+		Errorf(m.Logger, "Error when recovering the mail queue: %s", err)
+		return
+	}
+	var recovered int
+	for i := range emails {
+		e := &emails[i]
+		if e.Status == pub.EmailSending {
+			e.Status = pub.EmailQueued
+			e.NextAttempt = time.Now()
+			recovered++
+		}
+		if err = m.MailerService.UpdateEmail(e.ID, e); err != nil {
+			Errorf(m.Logger, "Email %s, error when queuing again: %s", e.UUID, err)
+		}
+	}
+	Infof(m.Logger, "%d emails queued again", recovered)
+}
+
+// prune deletes the emails sent before the retention.
+func (m *MailerHandler) prune() {
+	if m.retention <= 0 {
+		return
+	}
+	n, err := m.MailerService.PruneEmails(time.Now().Add(-m.retention))
+	if err != nil {
+		Errorf(m.Logger, "Error when pruning sent emails: %s", err)
+	} else if n > 0 {
+		Infof(m.Logger, "%d sent emails pruned", n)
+	}
+}
+
+// enqueue stores the email as queued and wakes up the dispatcher.
+func (m *MailerHandler) enqueue(email *pub.Email) error {
+	email.Status = pub.EmailQueued
+	email.Attempts = 0
+	email.Err = ""
+	email.Done = time.Time{}
+	email.NextAttempt = time.Now()
+	if err := m.MailerService.CreateEmail(email); err != nil {
+		return err
+	}
+	m.signal()
+	return nil
+}
+
+func (m *MailerHandler) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch sends the emails of the queue which are due, when an email is queued
+// and every mailPollInterval for the retries. The sent emails are pruned every
+// mailPruneInterval.
+func (m *MailerHandler) dispatch() {
+	ticker := time.NewTicker(mailPollInterval)
+	defer ticker.Stop()
+	pruner := time.NewTicker(mailPruneInterval)
+	defer pruner.Stop()
+	m.prune()
 	for {
 		select {
-		case email := <-m.incoming:
-			if email.Cfg != nil {
-				email.Sender = mailer.New(*email.Cfg)
-				email.Cfg = nil
+		case <-m.wake:
+		case <-ticker.C:
+		case <-pruner.C:
+			m.prune()
+			continue
+		}
+		queued, err := m.MailerService.QueuedEmails()
+		if err != nil {
+			Errorf(m.Logger, "Error when loading the mail queue: %s", err)
+			continue
+		}
+		now := time.Now()
+		for _, q := range queued {
+			if q.NextAttempt.After(now) {
+				continue
+			}
+			// an unknown profile fails the attempt, the email may be resent once the profile exists.
+			cfg, limit, err := m.smtpConfig(q.Profile)
+			if err == nil && !m.profiles.allow(q.Profile, limit, now) {
+				continue
+			}
+			m.mq <- struct{}{}
+			if e := m.claim(q.ID); e != nil {
+				go m.send(e, cfg, err)
 			} else {
-				email.Sender = m.defaultSender
+				<-m.mq
 			}
-			m.processing <- email
 		}
 	}
 }
 
-// get email from channel m.processing and deliver it to Email.Tos
-func (m *MailerHandler) sendQueue() {
-	for {
-		select {
-		case email := <-m.processing:
-			m.mq <- struct{}{}
-			go func(e *pub.Email, mq chan struct{}) {
-				tos := strings.Split(e.Tos, ",")
-				var err error
-				for i := 0; i < m.retry; i++ {
-					if err = e.Sender.Send(e.Subject, e.Content, tos...); err == nil {
-						break
-					}
-				}
-				if err != nil {
-					e.Err = err.Error()
-				} else {
-					e.Done = time.Now()
-				}
-				m.MailerService.CreateEmail(e)
-				<-mq
-			}(email, m.mq)
+// claim marks the email as being sent, unless it was resent or purged meanwhile.
+func (m *MailerHandler) claim(ID uint64) *pub.Email {
+	m.queue.Lock()
+	defer m.queue.Unlock()
+	e, err := m.MailerService.Email(ID)
+	if err != nil || !e.Status.Waiting() || e.NextAttempt.After(time.Now()) {
+		return nil
+	}
+	e.Status = pub.EmailSending
+	if err = m.MailerService.UpdateEmail(e.ID, e); err != nil {
+		Errorf(m.Logger, "Email %s, error when saving status: %s", e.UUID, err)
+		return nil
+	}
+	return e
+}
+
+// send makes an attempt to deliver the email unless its smtp server could not be
+// resolved, a failed email is retried after a backoff doubled on every attempt and
+// is dead after the last one.
+func (m *MailerHandler) send(e *pub.Email, cfg helper.SMTPConfig, err error) {
+	defer func() { <-m.mq }()
+	if err == nil {
+		err = helper.SendMail(cfg, newMailMessage(e))
+	}
+
+	m.queue.Lock()
+	defer m.queue.Unlock()
+	e.Attempts++
+	if err == nil {
+		e.Status = pub.EmailSent
+		e.Done = time.Now()
+		e.Err = ""
+	} else {
+		e.Err = err.Error()
+		if e.Attempts >= m.retry {
+			e.Status = pub.EmailDead
+			Errorf(m.Logger, "Email %s, dead after %d attempts: %s", e.UUID, e.Attempts, err)
+		} else {
+			e.Status = pub.EmailFailed
+			e.NextAttempt = time.Now().Add(m.backoffAfter(e.Attempts))
+		}
+	}
+	if err = m.MailerService.UpdateEmail(e.ID, e); err != nil {
+		Errorf(m.Logger, "Email %s, error when saving status: %s", e.UUID, err)
+	}
+}
+
+// backoffAfter returns the delay before the next attempt, after the given attempts.
+func (m *MailerHandler) backoffAfter(attempts int) time.Duration {
+	d := m.backoff
+	for i := 1; i < attempts && d < maxMailBackoff; i++ {
+		d *= 2
+	}
+	if d > maxMailBackoff {
+		d = maxMailBackoff
+	}
+	return d
+}
+
+// smtpConfig returns the smtp server and the rate limit of the profile, the server
+// of the flags without a profile.
+func (m *MailerHandler) smtpConfig(profile string) (helper.SMTPConfig, int, error) {
+	if profile == "" {
+		return m.defaultSMTP, 0, nil
+	}
+	p, err := m.MailerService.SMTPProfileByName(profile)
+	if err != nil {
+		return helper.SMTPConfig{}, 0, err
+	}
+	return p.Config(), p.RateLimit, nil
+}
+
+func newMailMessage(e *pub.Email) *helper.MailMessage {
+	msg := &helper.MailMessage{Subject: e.Subject, Text: e.Content, HTML: e.HTML}
+	for _, to := range strings.Split(e.Tos, ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			msg.To = append(msg.To, to)
+		}
+	}
+	for _, a := range e.Attachments {
+		msg.Attachments = append(msg.Attachments, helper.MailAttachment{Filename: a.Filename, ContentType: a.ContentType, Data: a.Data})
+	}
+	return msg
+}
+
+// Notify renders the email template of the name with data and queues the email,
+// an email sent by pubmgmt itself, e.g. an alert.
+func (m *MailerHandler) Notify(name string, data interface{}, tos string) {
+	email, err := m.render(name, data, tos)
+	if err != nil {
+		return
+	}
+	if err = m.enqueue(email); err != nil {
+		Errorf(m.Logger, "Email %s, error when queuing: %s", email.Subject, err)
+	}
+}
+
+// NotifyAs sends the email like Notify on behalf of the user, who chose the recipients,
+// so it goes through the mail policy like the emails the user posts.
+func (m *MailerHandler) NotifyAs(userID uint64, name string, data interface{}, tos string) {
+	email, err := m.render(name, data, tos)
+	if err != nil {
+		return
+	}
+	email.FromUserID = userID
+	if _, _, err = m.admit(email, time.Now()); err != nil {
+		Errorf(m.Logger, "Email %s of user %d, refused: %s", email.Subject, userID, err)
+	}
+}
+
+// render renders the email template for tos, the error has been logged.
+func (m *MailerHandler) render(name string, data interface{}, tos string) (*pub.Email, error) {
+	email := pub.NewEmail()
+	email.Tos = tos
+	tmpl, err := m.emailTemplate(name)
+	if err == nil {
+		err = tmpl.Render(data, &email)
+	}
+	if err != nil {
+		Errorf(m.Logger, "Email template %s, error when rendering: %s", name, err)
+		return nil, err
+	}
+	return &email, nil
+}
+
+// admit queues the email of its user once the mail policy allows it: its recipients and
+// attachments, then the daily quota and the rate of the user. The status code tells what
+// went wrong, and `retry` when to try again after too many requests.
+func (m *MailerHandler) admit(email *pub.Email, now time.Time) (code int, retry time.Time, err error) {
+	if code, err = m.policy.checkRecipients(email.Tos); err != nil {
+		return code, now, err
+	}
+	if err = m.policy.checkAttachments(email.Attachments); err != nil {
+		return http.StatusRequestEntityTooLarge, now, err
+	}
+	m.policy.quota.Lock()
+	defer m.policy.quota.Unlock()
+	if reset, err := m.policy.checkQuota(m.MailerService, email.FromUserID, now); err == pub.ErrMailQuotaExceeded {
+		return http.StatusTooManyRequests, reset, err
+	} else if err != nil {
+		return http.StatusInternalServerError, now, err
+	}
+	if !m.policy.allowRate(email.FromUserID, now) {
+		return http.StatusTooManyRequests, now.Add(time.Minute), pub.ErrMailRateLimited
+	}
+	if err = m.enqueue(email); err != nil {
+		return http.StatusInternalServerError, now, err
+	}
+	return http.StatusCreated, now, nil
+}
+
+// prepare renders the template of an email posted to the relay, checks it has a
+// body and that its smtp profile exists.
+func (m *MailerHandler) prepare(email *pub.Email) error {
+	if email.Profile != "" {
+		if _, err := m.MailerService.SMTPProfileByName(email.Profile); err != nil {
+			return err
+		}
+	}
+	if email.Template != "" {
+		tmpl, err := m.emailTemplate(email.Template)
+		if err != nil {
+			return err
 		}
+		if err = tmpl.Render(email.Data, email); err != nil {
+			return pub.Error(fmt.Sprintf("Email template %s: %s", email.Template, err))
+		}
+	}
+	if email.Subject == "" || (email.Content == "" && email.HTML == "") {
+		return pub.Error("Email requires a subject and a content or html body")
 	}
+	return nil
 }
 
 // url: /mailer  method: PUT  body: pub.Email
@@ -108,20 +385,113 @@ func (m *MailerHandler) createEmail(ctx *gin.Context) {
 		Error(ctx, err, http.StatusInternalServerError, nil)
 		return
 	}
+	if err = m.prepare(&req); err == pub.ErrEmailTemplateNotFound || err == pub.ErrSMTPProfileNotFound {
+		Error(ctx, err, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
 	req.FromUserID = tokenData.ID
-	m.incoming <- &req
+	now := time.Now()
+	if code, retry, err := m.admit(&req, now); err != nil {
+		switch code {
+		case http.StatusTooManyRequests:
+			retryAfter(ctx.Writer, now, retry)
+		case http.StatusInternalServerError:
+			Error(ctx, err, code, m.Logger)
+			return
+		}
+		Error(ctx, err, code, nil)
+		return
+	}
 	ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: fmt.Sprintf("Check `%s/mailer/%s` for detail later", ctx.Request.Host, req.UUID)})
 }
 
+// writeEmails writes the page of the emails selected by the filter, newest first.
+func (m *MailerHandler) writeEmails(ctx *gin.Context, filter *pub.EmailFilter) {
+	page, size, err := getPagination(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	emails, err := m.MailerService.Emails(filter)
+	if err == pub.ErrEmailOutboxEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	total := len(emails)
+	start := (page - 1) * size
+	if start > total {
+		start = total
+	}
+	end := start + size
+	if end > total {
+		end = total
+	}
+	items := make([]pub.Email, 0, end-start)
+	for i := total - 1 - start; i >= total-end; i-- {
+		items = append(items, emails[i])
+	}
+	ctx.IndentedJSON(http.StatusOK, &pageResponse{Total: total, Page: page, Size: size, Items: items})
+}
+
+// url: /mailer?status=:status&user_id=:id&from=:time&to=:time&page=:page&size=:size  method: GET
+// the emails of the caller, an administrator sees everyone's or those of `user_id`.
+func (m *MailerHandler) getEmails(ctx *gin.Context) {
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, nil)
+		return
+	}
+	statuses, err := emailStatuses(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	filter := &pub.EmailFilter{Status: statuses, UserID: tokenData.ID}
+	if tokenData.Role == pub.AdministratorRole {
+		filter.UserID = 0
+		if owner := ctx.Query("user_id"); owner != "" {
+			if filter.UserID, err = strconv.ParseUint(owner, 10, 64); err != nil {
+				Error(ctx, ErrInvalidQueryFormat, http.StatusBadRequest, nil)
+				return
+			}
+		}
+	}
+	for param, v := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := ctx.Query(param); value != "" {
+			if *v, err = time.Parse(time.RFC3339, value); err != nil {
+				Error(ctx, ErrInvalidQueryFormat, http.StatusBadRequest, nil)
+				return
+			}
+		}
+	}
+	m.writeEmails(ctx, filter)
+}
+
 // url: /mailer/:uuid  method: GET
-// get mail result
+// get mail result, of the caller unless an administrator asks.
 func (m *MailerHandler) getEmailDetail(ctx *gin.Context) {
-	uuid := ctx.Param("uuid")
-	if email, err := m.MailerService.EmailByUUID(uuid); err == pub.ErrEmailNotFound {
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, nil)
+		return
+	}
+	email, err := m.MailerService.EmailByUUID(ctx.Param("uuid"))
+	if err == pub.ErrEmailNotFound {
 		Error(ctx, err, http.StatusNotFound, nil)
+		return
 	} else if err != nil {
-		Error(ctx, err, http.StatusInternalServerError, nil)
-	} else {
-		ctx.JSON(http.StatusOK, email)
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	if tokenData.Role != pub.AdministratorRole && email.FromUserID != tokenData.ID {
+		Error(ctx, pub.ErrResourceAccessDenied, http.StatusForbidden, nil)
+		return
 	}
+	ctx.JSON(http.StatusOK, email)
 }
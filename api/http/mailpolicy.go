@@ -0,0 +1,139 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+)
+
+const (
+	// globalSender is the key of the rate limit of all the users.
+	globalSender = "*"
+	// maxAttachmentsSize is the size of the attachments of an email at most.
+	maxAttachmentsSize = 10 << 20
+)
+
+// mailPolicy limits the emails posted to the relay, its zero limits are unlimited.
+type mailPolicy struct {
+	userRate      int
+	globalRate    int
+	dailyQuota    int
+	maxRecipients int
+	domains       []string
+	senders       *rateLimiter
+	// quota serializes the check and the creation of the emails, so that concurrent
+	// requests cannot exceed the daily quota.
+	quota sync.Mutex
+}
+
+func newMailPolicy(flags *pub.CliFlags) *mailPolicy {
+	policy := &mailPolicy{
+		userRate:      *flags.MailUserRate,
+		globalRate:    *flags.MailGlobalRate,
+		dailyQuota:    *flags.MailDailyQuota,
+		maxRecipients: *flags.MailMaxRecipients,
+		senders:       newRateLimiter(time.Minute),
+	}
+	for _, domain := range strings.Split(*flags.MailDomains, ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			policy.domains = append(policy.domains, strings.TrimPrefix(domain, "."))
+		}
+	}
+	return policy
+}
+
+// checkRecipients parses the comma separated recipients, and checks their number
+// and their domains. The status code tells what went wrong.
+func (p *mailPolicy) checkRecipients(tos string) (int, error) {
+	var recipients []*mail.Address
+	for _, to := range strings.Split(tos, ",") {
+		if to = strings.TrimSpace(to); to == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return http.StatusBadRequest, pub.Error(fmt.Sprintf("Invalid recipient %q", to))
+		}
+		recipients = append(recipients, addr)
+	}
+	if len(recipients) == 0 {
+		return http.StatusBadRequest, pub.ErrNoRecipients
+	}
+	if p.maxRecipients > 0 && len(recipients) > p.maxRecipients {
+		return http.StatusForbidden, pub.ErrTooManyRecipients
+	}
+	for _, addr := range recipients {
+		if !p.allowedDomain(addr.Address) {
+			return http.StatusForbidden, pub.Error(fmt.Sprintf("Recipient domain of %s is not allowed", addr.Address))
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// checkAttachments checks the total size of the attachments.
+func (p *mailPolicy) checkAttachments(attachments []pub.EmailAttachment) error {
+	var size int
+	for _, a := range attachments {
+		size += len(a.Data)
+	}
+	if size > maxAttachmentsSize {
+		return pub.ErrAttachmentsTooLarge
+	}
+	return nil
+}
+
+// allowedDomain reports whether the domain of the address, or one of its parents,
+// is in the allow-list, an empty allow-list allows any domain.
+func (p *mailPolicy) allowedDomain(address string) bool {
+	if len(p.domains) == 0 {
+		return true
+	}
+	domain := strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+	for _, allowed := range p.domains {
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkQuota checks the emails of the user created since midnight against the daily
+// quota, it returns when the quota resets. Without authentication every request is
+// of user 0, which has no quota.
+func (p *mailPolicy) checkQuota(m pub.MailerService, userID uint64, now time.Time) (time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	reset := midnight.AddDate(0, 0, 1)
+	if p.dailyQuota <= 0 || userID == 0 {
+		return reset, nil
+	}
+	emails, err := m.Emails(&pub.EmailFilter{UserID: userID, From: midnight})
+	if err == pub.ErrEmailOutboxEmpty {
+		return reset, nil
+	} else if err != nil {
+		return reset, err
+	}
+	if len(emails) >= p.dailyQuota {
+		return reset, pub.ErrMailQuotaExceeded
+	}
+	return reset, nil
+}
+
+// allowRate records an email of the user unless the user or all of them have sent
+// too many in the last minute, a refused email counts against neither.
+func (p *mailPolicy) allowRate(userID uint64, now time.Time) bool {
+	return p.senders.allowAll(now,
+		rateLimit{strconv.FormatUint(userID, 10), p.userRate},
+		rateLimit{globalSender, p.globalRate})
+}
+
+// retryAfter sets the Retry-After header to the seconds until t, one at least.
+func retryAfter(w http.ResponseWriter, now, t time.Time) {
+	seconds := int(t.Sub(now)/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
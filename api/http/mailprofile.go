@@ -0,0 +1,193 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/helper"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// url: /mailprofiles  method: PUT  body: pub.SMTPProfile
+func (m *MailerHandler) createSMTPProfile(ctx *gin.Context) {
+	var req pub.SMTPProfile
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if _, err := m.MailerService.SMTPProfileByName(req.Name); err == nil {
+		Error(ctx, pub.ErrSMTPProfileAlreadyExists, http.StatusConflict, nil)
+		return
+	} else if err != pub.ErrSMTPProfileNotFound {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	req.ID = 0
+	req.Created = time.Now()
+	req.Updated = req.Created
+	if err := m.MailerService.CreateSMTPProfile(&req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: "Put smtp profile success"})
+}
+
+// url: /mailprofiles  method: GET
+// passwords are never returned.
+func (m *MailerHandler) getSMTPProfiles(ctx *gin.Context) {
+	profiles, err := m.MailerService.SMTPProfiles()
+	if err == pub.ErrSMTPProfileSetEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	for i := range profiles {
+		profiles[i].Password = ""
+	}
+	ctx.IndentedJSON(http.StatusOK, profiles)
+}
+
+// smtpProfileByID returns the profile of the `id` param, the error has been written to the response.
+func (m *MailerHandler) smtpProfileByID(ctx *gin.Context) (*pub.SMTPProfile, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return nil, err
+	}
+	profile, err := m.MailerService.SMTPProfile(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrSMTPProfileNotFound, http.StatusNotFound, nil)
+		return nil, err
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return nil, err
+	}
+	return profile, nil
+}
+
+// url: /mailprofiles/:id  method: GET
+func (m *MailerHandler) getSMTPProfileByID(ctx *gin.Context) {
+	if profile, err := m.smtpProfileByID(ctx); err == nil {
+		profile.Password = ""
+		ctx.IndentedJSON(http.StatusOK, profile)
+	}
+}
+
+// url: /mailprofiles/:id  method: POST  body: pub.SMTPProfile
+// the password is kept when it is empty.
+func (m *MailerHandler) updateSMTPProfileByID(ctx *gin.Context) {
+	profile, err := m.smtpProfileByID(ctx)
+	if err != nil {
+		return
+	}
+	var req pub.SMTPProfile
+	if err = ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if req.ID == 0 || req.ID != profile.ID {
+		Error(ctx, errIDField, http.StatusBadRequest, nil)
+		return
+	}
+	if err = req.Validate(); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if req.Name != profile.Name {
+		// queued emails refer to the profile by its name.
+		if code, err := m.checkProfileUnused(profile.Name); err != nil {
+			Error(ctx, err, code, nil)
+			return
+		}
+		if _, err = m.MailerService.SMTPProfileByName(req.Name); err == nil {
+			Error(ctx, pub.ErrSMTPProfileAlreadyExists, http.StatusConflict, nil)
+			return
+		} else if err != pub.ErrSMTPProfileNotFound {
+			Error(ctx, err, http.StatusInternalServerError, m.Logger)
+			return
+		}
+	}
+	if req.Password == "" {
+		req.Password = profile.Password
+	}
+	req.Created = profile.Created
+	req.Updated = time.Now()
+	if err = m.MailerService.UpdateSMTPProfile(profile.ID, &req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Update smtp profile success"})
+}
+
+// url: /mailprofiles/:id  method: DELETE
+func (m *MailerHandler) deleteSMTPProfileByID(ctx *gin.Context) {
+	profile, err := m.smtpProfileByID(ctx)
+	if err != nil {
+		return
+	}
+	if code, err := m.checkProfileUnused(profile.Name); err != nil {
+		Error(ctx, err, code, nil)
+		return
+	}
+	if err = m.MailerService.DeleteSMTPProfile(profile.ID); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Delete smtp profile success"})
+}
+
+// checkProfileUnused fails when emails not sent yet are sent through the profile.
+func (m *MailerHandler) checkProfileUnused(name string) (int, error) {
+	emails, err := m.MailerService.Emails(&pub.EmailFilter{Status: []pub.EmailStatus{pub.EmailQueued, pub.EmailSending, pub.EmailFailed}})
+	if err == pub.ErrEmailOutboxEmpty {
+		return http.StatusOK, nil
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, e := range emails {
+		if e.Profile == name {
+			return http.StatusConflict, pub.ErrSMTPProfileInUse
+		}
+	}
+	return http.StatusOK, nil
+}
+
+type testSMTPProfileRequest struct {
+	To string `json:"to" binding:"required"`
+}
+
+// url: /mailer/profiles/:name/test  method: POST  body: testSMTPProfileRequest
+// send a test email through the profile right away, bypassing the queue.
+func (m *MailerHandler) testSMTPProfile(ctx *gin.Context) {
+	var req testSMTPProfileRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	profile, err := m.MailerService.SMTPProfileByName(ctx.Param("name"))
+	if err == pub.ErrSMTPProfileNotFound {
+		Error(ctx, err, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	msg := &helper.MailMessage{
+		To:      []string{req.To},
+		Subject: "[pubmgmt] test of smtp profile " + profile.Name,
+		Text:    "This email was sent by pubmgmt to test the smtp profile " + profile.Name + ".\n",
+	}
+	if err = helper.SendMail(profile.Config(), msg); err != nil {
+		Error(ctx, err, http.StatusBadGateway, nil)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Test email sent"})
+}
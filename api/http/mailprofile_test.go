@@ -0,0 +1,127 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// profileStore serves the smtp profiles by their name, the other methods of the
+// service are not implemented.
+type profileStore struct {
+	pub.MailerService
+	profiles map[string]*pub.SMTPProfile
+}
+
+func (s *profileStore) SMTPProfileByName(name string) (*pub.SMTPProfile, error) {
+	if p, ok := s.profiles[name]; ok {
+		return p, nil
+	}
+	return nil, pub.ErrSMTPProfileNotFound
+}
+
+// listenSMTP accepts the emails sent to an smtp server on the loopback, the
+// recipients of each email are sent on the channel.
+func listenSMTP(t *testing.T) (net.Listener, <-chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcpts := make(chan []string, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tc := textproto.NewConn(conn)
+				var to []string
+				tc.PrintfLine("220 localhost ESMTP")
+				for {
+					line, err := tc.ReadLine()
+					if err != nil {
+						return
+					}
+					switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+					case "RCPT":
+						to = append(to, strings.TrimPrefix(line, "RCPT TO:"))
+						tc.PrintfLine("250 OK")
+					case "DATA":
+						tc.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+						if _, err = tc.ReadDotBytes(); err != nil {
+							return
+						}
+						tc.PrintfLine("250 OK")
+					case "QUIT":
+						tc.PrintfLine("221 Bye")
+						rcpts <- to
+						return
+					default:
+						tc.PrintfLine("250 OK")
+					}
+				}
+			}()
+		}
+	}()
+	return ln, rcpts
+}
+
+func postProfileTest(m *MailerHandler, name, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/mailer/profiles/:name/test", m.testSMTPProfile)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/mailer/profiles/"+name+"/test", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTestSMTPProfile(t *testing.T) {
+	ln, rcpts := listenSMTP(t)
+	defer ln.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	m := &MailerHandler{MailerService: &profileStore{profiles: map[string]*pub.SMTPProfile{
+		"relay": {Name: "relay", Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, TLS: "none", From: "pub@example.com"},
+		"down":  {Name: "down", Host: "127.0.0.1", Port: closed.Addr().(*net.TCPAddr).Port, TLS: "none", From: "pub@example.com"},
+	}}}
+
+	w := postProfileTest(m, "relay", `{"to": "ops@example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("relay: code = %d, body %s", w.Code, w.Body)
+	}
+	select {
+	case to := <-rcpts:
+		if len(to) != 1 || to[0] != "<ops@example.com>" {
+			t.Errorf("recipients = %v, want <ops@example.com>", to)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay: no email received")
+	}
+
+	for _, c := range []struct {
+		name, body string
+		code       int
+	}{
+		{"down", `{"to": "ops@example.com"}`, http.StatusBadGateway},
+		{"unknown", `{"to": "ops@example.com"}`, http.StatusNotFound},
+		{"relay", `{}`, http.StatusBadRequest},
+	} {
+		if w := postProfileTest(m, c.name, c.body); w.Code != c.code {
+			t.Errorf("%s %s: code = %d, want %d", c.name, c.body, w.Code, c.code)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// emailStatuses reads the comma separated `status` of the query, or returns the defaults.
+func emailStatuses(ctx *gin.Context, defaults ...pub.EmailStatus) ([]pub.EmailStatus, error) {
+	status := ctx.Query("status")
+	if status == "" {
+		return defaults, nil
+	}
+	var statuses []pub.EmailStatus
+	for _, s := range strings.Split(status, ",") {
+		s := pub.EmailStatus(strings.TrimSpace(s))
+		if !s.Valid() {
+			return nil, pub.Error(fmt.Sprintf("Unknown email status %q", s))
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// url: /mailqueue?status=:status&page=:page&size=:size  method: GET
+// the emails which are not sent yet unless `status` tells otherwise.
+func (m *MailerHandler) getMailQueue(ctx *gin.Context) {
+	statuses, err := emailStatuses(ctx, pub.EmailQueued, pub.EmailSending, pub.EmailFailed, pub.EmailDead)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	m.writeEmails(ctx, &pub.EmailFilter{Status: statuses})
+}
+
+// queuedEmail returns the email of the `id` param, the error has been written to the response.
+func (m *MailerHandler) queuedEmail(ctx *gin.Context) (*pub.Email, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return nil, err
+	}
+	email, err := m.MailerService.Email(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrEmailNotFound, http.StatusNotFound, nil)
+		return nil, err
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return nil, err
+	}
+	switch email.Status {
+	case pub.EmailSending:
+		err = pub.ErrEmailSending
+	case pub.EmailSent:
+		err = pub.ErrEmailSent
+	}
+	if err != nil {
+		Error(ctx, err, http.StatusConflict, nil)
+		return nil, err
+	}
+	return email, nil
+}
+
+// url: /mailqueue/:id/resend  method: POST
+// queue a failed or dead email again, with its attempts reset.
+func (m *MailerHandler) resendEmail(ctx *gin.Context) {
+	m.queue.Lock()
+	email, err := m.queuedEmail(ctx)
+	if err != nil {
+		m.queue.Unlock()
+		return
+	}
+	email.Status = pub.EmailQueued
+	email.Attempts = 0
+	email.Err = ""
+	email.NextAttempt = time.Now()
+	err = m.MailerService.UpdateEmail(email.ID, email)
+	m.queue.Unlock()
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	m.signal()
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Email queued"})
+}
+
+// url: /mailqueue/:id  method: DELETE
+func (m *MailerHandler) purgeEmail(ctx *gin.Context) {
+	m.queue.Lock()
+	defer m.queue.Unlock()
+	email, err := m.queuedEmail(ctx)
+	if err != nil {
+		return
+	}
+	if err = m.MailerService.DeleteEmail(email.ID); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Email purged"})
+}
+
+// url: /mailqueue?status=:status  method: DELETE
+// purge the queued, failed or dead emails of `status`, which is required.
+func (m *MailerHandler) purgeMailQueue(ctx *gin.Context) {
+	statuses, err := emailStatuses(ctx)
+	if err == nil && len(statuses) == 0 {
+		err = pub.Error("Status of the emails to purge is required")
+	}
+	for _, s := range statuses {
+		if s == pub.EmailSending || s == pub.EmailSent {
+			err = pub.Error(fmt.Sprintf("Emails %s cannot be purged", s))
+		}
+	}
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	m.queue.Lock()
+	defer m.queue.Unlock()
+	emails, err := m.MailerService.Emails(&pub.EmailFilter{Status: statuses})
+	if err == pub.ErrEmailOutboxEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	for _, email := range emails {
+		if err = m.MailerService.DeleteEmail(email.ID); err != nil {
+			Error(ctx, err, http.StatusInternalServerError, m.Logger)
+			return
+		}
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: fmt.Sprintf("%d emails purged", len(emails))})
+}
@@ -0,0 +1,233 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// defaultEmailTemplates render the emails of pubmgmt unless a stored template has the same name.
+var defaultEmailTemplates = map[string]*pub.EmailTemplate{
+	pub.TemplateTaskReport: {
+		Name:    pub.TemplateTaskReport,
+		Subject: "[pubmgmt] task {{.Task.Name}}: {{.Task.Status}}",
+		Text: `Task: {{.Task.Name}} (#{{.Task.ID}})
+Status: {{.Task.Status}}
+Done: {{.Task.Done.Format "2006-01-02T15:04:05Z07:00"}}
+{{range $host, $result := .Results}}
+== {{$host}}
+{{$result}}
+{{end}}`,
+		HTML: `<p>Task <b>{{.Task.Name}}</b> (#{{.Task.ID}}): {{.Task.Status}}</p>
+<p>Done: {{.Task.Done.Format "2006-01-02T15:04:05Z07:00"}}</p>
+{{range $host, $result := .Results}}<h4>{{$host}}</h4><pre>{{$result}}</pre>
+{{end}}`,
+	},
+	pub.TemplateApprovalRequest: {
+		Name:    pub.TemplateApprovalRequest,
+		Subject: "[pubmgmt] task {{.Task.Name}} requires approval",
+		Text: `Task: {{.Task.Name}} (#{{.Task.ID}})
+Comment: {{.Task.Comment}}
+Hosts: {{range $i, $h := .Task.Hosts}}{{if $i}}, {{end}}{{$h}}{{end}}
+Commands:
+{{range .Commands}}  {{.}}
+{{end}}Approvals required: {{.Required}}
+Expires: {{.Expires}}
+
+Approve: POST {{.ApproveURL}}
+Reject: POST {{.RejectURL}}
+`,
+		HTML: `<p>Task <b>{{.Task.Name}}</b> (#{{.Task.ID}}) requires {{.Required}} approval(s), it expires {{.Expires}}.</p>
+<p>Comment: {{.Task.Comment}}</p>
+<p>Hosts: {{range $i, $h := .Task.Hosts}}{{if $i}}, {{end}}{{$h}}{{end}}</p>
+<pre>{{range .Commands}}{{.}}
+{{end}}</pre>
+<p>Approve: POST {{.ApproveURL}}<br>Reject: POST {{.RejectURL}}</p>`,
+	},
+	pub.TemplateApprovalDecision: {
+		Name:    pub.TemplateApprovalDecision,
+		Subject: "[pubmgmt] task {{.Task.Name}} {{.Decision}}",
+		Text: `Task: {{.Task.Name}} (#{{.Task.ID}})
+Approval: {{.Decision}}{{if .Comment}}: {{.Comment}}{{end}}
+Time: {{.Time.Format "2006-01-02T15:04:05Z07:00"}}
+`,
+		HTML: `<p>Task <b>{{.Task.Name}}</b> (#{{.Task.ID}}) {{.Decision}}{{if .Comment}}: {{.Comment}}{{end}}</p>
+<p>Time: {{.Time.Format "2006-01-02T15:04:05Z07:00"}}</p>`,
+	},
+	pub.TemplateCronAlert: {
+		Name:    pub.TemplateCronAlert,
+		Subject: "[pubmgmt] cron {{.Cron}}: {{.Event}}",
+		Text: `Cron: {{.Cron}} (#{{.ID}})
+Event: {{.Event}}
+Consecutive failures: {{.Fails}}
+Error: {{.Err}}
+Time: {{.Time.Format "2006-01-02T15:04:05Z07:00"}}
+`,
+		HTML: `<p>Cron <b>{{.Cron}}</b> (#{{.ID}}): {{.Event}}</p>
+<p>Consecutive failures: {{.Fails}}<br>Error: {{.Err}}<br>Time: {{.Time.Format "2006-01-02T15:04:05Z07:00"}}</p>`,
+	},
+}
+
+// emailTemplate returns the stored template of the name, or the built-in one.
+func (m *MailerHandler) emailTemplate(name string) (*pub.EmailTemplate, error) {
+	tmpl, err := m.MailerService.EmailTemplateByName(name)
+	if err == pub.ErrEmailTemplateNotFound {
+		if tmpl, ok := defaultEmailTemplates[name]; ok {
+			return tmpl, nil
+		}
+	}
+	return tmpl, err
+}
+
+// url: /mailtemplates  method: PUT  body: pub.EmailTemplate
+func (m *MailerHandler) createEmailTemplate(ctx *gin.Context) {
+	var req pub.EmailTemplate
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if _, err := m.MailerService.EmailTemplateByName(req.Name); err == nil {
+		Error(ctx, pub.ErrEmailTemplateAlreadyExists, http.StatusConflict, nil)
+		return
+	} else if err != pub.ErrEmailTemplateNotFound {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	req.ID = 0
+	req.Created = time.Now()
+	req.Updated = req.Created
+	if err := m.MailerService.CreateEmailTemplate(&req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: "Put email template success"})
+}
+
+// url: /mailtemplates?builtin=true  method: GET
+// the built-in templates are listed with `builtin`.
+func (m *MailerHandler) getEmailTemplates(ctx *gin.Context) {
+	if ctx.Query("builtin") == "true" {
+		var templates []*pub.EmailTemplate
+		for _, name := range []string{pub.TemplateTaskReport, pub.TemplateApprovalRequest, pub.TemplateApprovalDecision, pub.TemplateCronAlert} {
+			templates = append(templates, defaultEmailTemplates[name])
+		}
+		ctx.IndentedJSON(http.StatusOK, templates)
+		return
+	}
+	templates, err := m.MailerService.EmailTemplates()
+	if err == pub.ErrEmailTemplateSetEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, templates)
+	}
+}
+
+// emailTemplateByID returns the template of the `id` param, the error has been written to the response.
+func (m *MailerHandler) emailTemplateByID(ctx *gin.Context) (*pub.EmailTemplate, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return nil, err
+	}
+	tmpl, err := m.MailerService.EmailTemplate(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrEmailTemplateNotFound, http.StatusNotFound, nil)
+		return nil, err
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// url: /mailtemplates/:id  method: GET
+func (m *MailerHandler) getEmailTemplateByID(ctx *gin.Context) {
+	if tmpl, err := m.emailTemplateByID(ctx); err == nil {
+		ctx.IndentedJSON(http.StatusOK, tmpl)
+	}
+}
+
+// url: /mailtemplates/:id  method: POST  body: pub.EmailTemplate
+func (m *MailerHandler) updateEmailTemplateByID(ctx *gin.Context) {
+	tmpl, err := m.emailTemplateByID(ctx)
+	if err != nil {
+		return
+	}
+	var req pub.EmailTemplate
+	if err = ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if req.ID == 0 || req.ID != tmpl.ID {
+		Error(ctx, errIDField, http.StatusBadRequest, nil)
+		return
+	}
+	if err = req.Validate(); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if req.Name != tmpl.Name {
+		if _, err = m.MailerService.EmailTemplateByName(req.Name); err == nil {
+			Error(ctx, pub.ErrEmailTemplateAlreadyExists, http.StatusConflict, nil)
+			return
+		} else if err != pub.ErrEmailTemplateNotFound {
+			Error(ctx, err, http.StatusInternalServerError, m.Logger)
+			return
+		}
+	}
+	req.Created = tmpl.Created
+	req.Updated = time.Now()
+	if err = m.MailerService.UpdateEmailTemplate(tmpl.ID, &req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Update email template success"})
+}
+
+// url: /mailtemplates/:id  method: DELETE
+func (m *MailerHandler) deleteEmailTemplateByID(ctx *gin.Context) {
+	tmpl, err := m.emailTemplateByID(ctx)
+	if err != nil {
+		return
+	}
+	if err = m.MailerService.DeleteEmailTemplate(tmpl.ID); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, m.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Delete email template success"})
+}
+
+// previewResponse is an email template rendered with the posted data.
+type emailPreviewResponse struct {
+	Subject string `json:"subject"`
+	Content string `json:"content,omitempty"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// url: /mailtemplates/:id/preview  method: POST  body: map[string]interface{}
+func (m *MailerHandler) previewEmailTemplate(ctx *gin.Context) {
+	tmpl, err := m.emailTemplateByID(ctx)
+	if err != nil {
+		return
+	}
+	var data map[string]interface{}
+	if err = ctx.BindJSON(&data); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	var email pub.Email
+	if err = tmpl.Render(data, &email); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &emailPreviewResponse{Subject: email.Subject, Content: email.Content, HTML: email.HTML})
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"mime"
 	"net/http"
 	"strings"
 
@@ -33,6 +34,18 @@ func mwSecureHeaders(ctx *gin.Context) {
 	ctx.Next()
 }
 
+// mwRequireJSON refuses requests which are not JSON, so that an html form or a link
+// of another site cannot trigger the action, the token is only read from the
+// Authorization header which browsers never send on their own.
+func mwRequireJSON(ctx *gin.Context) {
+	mediaType, _, err := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		ErrorAbort(ctx, ErrJSONRequired, http.StatusUnsupportedMediaType, nil)
+		return
+	}
+	ctx.Next()
+}
+
 // mwCheckAdministratorRole check the role of the user associated to the request
 func (service *middleWareService) mwCheckAdministratorRole() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"sync"
+
+	"github.com/fengxsong/pubmgmt/api"
+)
+
+// jobLocks enforces the overlap policy of the jobs, keyed like the scheduler entries.
+type jobLocks struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	jobs map[string]*jobState
+}
+
+type jobState struct {
+	running int
+	queued  bool
+}
+
+func newJobLocks() *jobLocks {
+	l := &jobLocks{jobs: make(map[string]*jobState)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func validOverlap(overlap string) bool {
+	return overlap == "" || overlap == pub.OverlapAllow || overlap == pub.OverlapSkip || overlap == pub.OverlapQueue
+}
+
+// run calls fn unless the overlap policy tells to skip it, which is reported by false.
+// With OverlapQueue a single fire waits for the running one, any further fire is skipped.
+// An empty overlap is OverlapAllow.
+func (l *jobLocks) run(key, overlap string, fn func()) bool {
+	l.mu.Lock()
+	job, ok := l.jobs[key]
+	if !ok {
+		job = &jobState{}
+		l.jobs[key] = job
+	}
+	if job.running > 0 {
+		switch overlap {
+		case pub.OverlapSkip:
+			l.mu.Unlock()
+			return false
+		case pub.OverlapQueue:
+			if job.queued {
+				l.mu.Unlock()
+				return false
+			}
+			job.queued = true
+			for job.running > 0 {
+				l.cond.Wait()
+			}
+			job.queued = false
+		}
+	}
+	job.running++
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		job.running--
+		if job.running == 0 && !job.queued {
+			delete(l.jobs, key)
+		}
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	}()
+	fn()
+	return true
+}
@@ -0,0 +1,157 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/api/policy"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+type PolicyHandler struct {
+	Logger         logger
+	PolicyService  pub.PolicyService
+	CommandChecker pub.CommandChecker
+}
+
+// url: /policies  method: PUT  body: pub.CommandPolicy
+func (p *PolicyHandler) createPolicy(ctx *gin.Context) {
+	var req pub.CommandPolicy
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if err := policy.Validate(&req); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	policies, err := p.PolicyService.Policies()
+	if err != nil && err != pub.ErrPolicySetEmpty {
+		Error(ctx, err, http.StatusInternalServerError, p.Logger)
+		return
+	}
+	for _, item := range policies {
+		if item.Name == req.Name {
+			Error(ctx, pub.ErrPolicyAlreadyExists, http.StatusConflict, nil)
+			return
+		}
+	}
+	req.ID = 0
+	if err = p.PolicyService.CreatePolicy(&req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, p.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: "Put policy success"})
+}
+
+// url: /policies  method: GET
+func (p *PolicyHandler) getPolicies(ctx *gin.Context) {
+	policies, err := p.PolicyService.Policies()
+	if err == pub.ErrPolicySetEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, p.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, policies)
+	}
+}
+
+// url: /policies/detail/:id  method: GET
+func (p *PolicyHandler) getPolicyByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	item, err := p.PolicyService.Policy(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrPolicyNotFound, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, p.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, item)
+	}
+}
+
+// url: /policies/detail/:id  method: POST  body: pub.CommandPolicy
+func (p *PolicyHandler) updatePolicyByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	var req pub.CommandPolicy
+	if err = ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if req.ID == 0 || req.ID != id {
+		Error(ctx, errIDField, http.StatusBadRequest, nil)
+		return
+	}
+	if err = policy.Validate(&req); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	_, err = p.PolicyService.Policy(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrPolicyNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, p.Logger)
+		return
+	}
+	if err = p.PolicyService.UpdatePolicy(id, &req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, p.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Update policy success"})
+}
+
+// url: /policies/detail/:id  method: DELETE
+func (p *PolicyHandler) deletePolicyByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	_, err = p.PolicyService.Policy(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrPolicyNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, p.Logger)
+		return
+	}
+	if err = p.PolicyService.DeletePolicy(id); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, p.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Delete policy success"})
+}
+
+// url: /policies/check  method: POST  body: postPolicyCheckRequest
+// check a command without creating a task.
+func (p *PolicyHandler) checkCommand(ctx *gin.Context) {
+	var req postPolicyCheckRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if err := p.CommandChecker.Check(req.Role, req.HostgroupIDs, req.Command); err != nil {
+		if _, ok := err.(*pub.PolicyViolation); ok {
+			Error(ctx, err, http.StatusForbidden, nil)
+		} else {
+			Error(ctx, err, http.StatusInternalServerError, p.Logger)
+		}
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Command is allowed"})
+}
+
+type postPolicyCheckRequest struct {
+	Role         pub.UserRole `json:"role" binding:"required"`
+	HostgroupIDs []uint64     `json:"hostgroup_ids"`
+	Command      string       `json:"command" binding:"required"`
+}
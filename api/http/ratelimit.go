@@ -0,0 +1,58 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter counts the events of each key in a sliding window.
+type rateLimiter struct {
+	window time.Duration
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+func newRateLimiter(window time.Duration) *rateLimiter {
+	return &rateLimiter{window: window, events: make(map[string][]time.Time)}
+}
+
+// rateLimit is the number of events a key may have in the window, 0 is unlimited.
+type rateLimit struct {
+	key   string
+	limit int
+}
+
+// allow records an event of the key at now unless there are already limit events
+// in the window, a limit of 0 allows everything.
+func (r *rateLimiter) allow(key string, limit int, now time.Time) bool {
+	return r.allowAll(now, rateLimit{key, limit})
+}
+
+// allowAll records an event of every key at now when none of them has reached its
+// limit, otherwise it records nothing.
+func (r *rateLimiter) allowAll(now time.Time, limits ...rateLimit) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, l := range limits {
+		if l.limit > 0 && len(r.expire(l.key, now)) >= l.limit {
+			return false
+		}
+	}
+	for _, l := range limits {
+		if l.limit > 0 {
+			r.events[l.key] = append(r.events[l.key], now)
+		}
+	}
+	return true
+}
+
+// expire drops the events of the key out of the window, it must be called with r.mu held.
+func (r *rateLimiter) expire(key string, now time.Time) []time.Time {
+	events := r.events[key]
+	i := 0
+	for i < len(events) && !events[i].After(now.Add(-r.window)) {
+		i++
+	}
+	r.events[key] = events[i:]
+	return r.events[key]
+}
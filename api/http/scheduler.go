@@ -0,0 +1,383 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/robfig/cron"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// scheduler owns the scheduled tasks and crons, keyed by `task.<id>` and `cron.<id>`.
+// robfig/cron can not remove an entry, so every entry is registered once with the
+// cron and disabled when it is removed or replaced: it does not run anymore, and it
+// is parked the next time the cron asks for its fire time. Setting a job on the same
+// spec and timezone keeps the registration, so @every specs keep their phase. Once
+// `maxDisabled` entries are disabled the cron is rebuilt with the enabled ones only.
+type scheduler struct {
+	// cronMu serializes the registrations with the rebuilds of the cron, it is taken before mu.
+	cronMu   sync.Mutex
+	mu       sync.Mutex
+	cron     *cron.Cron
+	entries  map[string]*schedulerEntry
+	disabled int
+}
+
+// maxDisabled is the number of disabled entries the cron keeps before it is rebuilt.
+const maxDisabled = 100
+
+// schedulerEntry reports the fire times in the timezone of the job and in UTC.
+type schedulerEntry struct {
+	Key      string    `json:"key"`
+	Name     string    `json:"name"`
+	Spec     string    `json:"spec"`
+	Timezone string    `json:"timezone"`
+	Next     time.Time `json:"next"`
+	NextUTC  time.Time `json:"next_utc"`
+	Prev     time.Time `json:"prev,omitempty"`
+	PrevUTC  time.Time `json:"prev_utc,omitempty"`
+	schedule cron.Schedule
+	job      func()
+	disabled bool
+	s        *scheduler
+}
+
+// entrySchedule is the schedule the cron knows an entry by, a disabled entry never fires again.
+// An entry moved to a rebuilt cron first fires at `resume`, the time it had in the previous one.
+type entrySchedule struct {
+	e      *schedulerEntry
+	resume time.Time
+}
+
+func (s entrySchedule) Next(t time.Time) time.Time {
+	s.e.s.mu.Lock()
+	disabled := s.e.disabled
+	s.e.s.mu.Unlock()
+	if disabled {
+		return time.Time{}
+	}
+	if s.resume.After(t) {
+		return s.resume
+	}
+	return s.e.schedule.Next(t)
+}
+
+// Run implements cron.Job, it keeps track of the previous fire time across updates of the job.
+func (e *schedulerEntry) Run() {
+	e.s.mu.Lock()
+	if e.disabled {
+		e.s.mu.Unlock()
+		return
+	}
+	e.Prev = time.Now()
+	job := e.job
+	e.s.mu.Unlock()
+	job()
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{
+		cron:    cron.New(),
+		entries: make(map[string]*schedulerEntry),
+	}
+	s.cron.Start()
+	return s
+}
+
+func taskKey(id uint64) string { return fmt.Sprintf("%s%d", taskPrefix, id) }
+
+func cronKey(id uint64) string { return fmt.Sprintf("%s%d", cronPrefix, id) }
+
+// parseSpec parses spec with the 6 fields format (seconds first) or a descriptor like `@every 5m`.
+func parseSpec(spec string) (cron.Schedule, error) {
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return nil, pub.Error(fmt.Sprintf("Invalid spec %q: %s", spec, err))
+	}
+	return schedule, nil
+}
+
+// loadLocation loads the IANA timezone name, the empty name is the server's timezone.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, pub.Error(fmt.Sprintf("Invalid timezone %q", name))
+	}
+	return loc, nil
+}
+
+// tzSchedule computes the fire times in its own timezone, whatever the timezone of the server.
+type tzSchedule struct {
+	cron.Schedule
+	loc *time.Location
+}
+
+func (s *tzSchedule) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t.In(s.loc))
+}
+
+// Set adds the job under key, or replaces the one registered already.
+func (s *scheduler) Set(key, name, spec, timezone string, job func()) error {
+	schedule, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+	loc, err := loadLocation(timezone)
+	if err != nil {
+		return err
+	}
+	s.cronMu.Lock()
+	defer s.cronMu.Unlock()
+	s.mu.Lock()
+	if old, ok := s.entries[key]; ok {
+		if old.Spec == spec && old.Timezone == loc.String() {
+			old.Name, old.job = name, job
+			s.mu.Unlock()
+			return nil
+		}
+		s.disable(old)
+	}
+	e := &schedulerEntry{
+		Key:      key,
+		Name:     name,
+		Spec:     spec,
+		Timezone: loc.String(),
+		schedule: &tzSchedule{Schedule: schedule, loc: loc},
+		job:      job,
+		s:        s,
+	}
+	if old, ok := s.entries[key]; ok {
+		e.Prev = old.Prev
+	}
+	s.entries[key] = e
+	s.mu.Unlock()
+	// the cron asks the entry for its fire time when it is added, which takes s.mu.
+	s.cron.Schedule(entrySchedule{e: e}, e)
+	s.compact()
+	return nil
+}
+
+// Remove unregisters the job under key, a job already running is not interrupted.
+func (s *scheduler) Remove(key string) {
+	s.cronMu.Lock()
+	defer s.cronMu.Unlock()
+	s.mu.Lock()
+	if e, ok := s.entries[key]; ok {
+		s.disable(e)
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+	s.compact()
+}
+
+// disable must be called with s.mu held.
+func (s *scheduler) disable(e *schedulerEntry) {
+	e.disabled = true
+	s.disabled++
+}
+
+// compact replaces the cron by a new one holding the enabled entries only, once
+// `maxDisabled` entries are disabled. s.cronMu must be held.
+func (s *scheduler) compact() {
+	s.mu.Lock()
+	if s.disabled < maxDisabled {
+		s.mu.Unlock()
+		return
+	}
+	s.disabled = 0
+	s.mu.Unlock()
+	old := s.cron
+	old.Stop()
+	s.cron = cron.New()
+	for _, entry := range old.Entries() {
+		e, ok := entry.Job.(*schedulerEntry)
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		disabled := e.disabled
+		s.mu.Unlock()
+		if !disabled {
+			s.cron.Schedule(entrySchedule{e: e, resume: entry.Next}, e)
+		}
+	}
+	s.cron.Start()
+}
+
+// Entries returns a snapshot of the registered jobs, sorted by their next fire time.
+func (s *scheduler) Entries() []schedulerEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	entries := make([]schedulerEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e.snapshot(now))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Next.Before(entries[j].Next) })
+	return entries
+}
+
+// Entry returns a snapshot of the job under key, nil when it is not scheduled.
+func (s *scheduler) Entry(key string) *schedulerEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := e.snapshot(time.Now())
+	return &entry
+}
+
+// snapshot must be called with s.mu held.
+func (e *schedulerEntry) snapshot(now time.Time) schedulerEntry {
+	entry := *e
+	entry.Next = e.schedule.Next(now)
+	entry.NextUTC = entry.Next.UTC()
+	if !e.Prev.IsZero() {
+		entry.Prev = e.Prev.In(e.schedule.(*tzSchedule).loc)
+		entry.PrevUTC = e.Prev.UTC()
+	}
+	return entry
+}
+
+// scheduleTask registers the task by its spec, a suspended or unapproved task is removed.
+// Fires outside of the maintenance windows are skipped.
+func (t *TaskHandler) scheduleTask(task *pub.Task) error {
+	if task.Spec == "" || task.Suspended || notApproved(task) {
+		t.scheduler.Remove(taskKey(task.ID))
+		return nil
+	}
+	return t.scheduler.Set(taskKey(task.ID), task.Name, task.Spec, task.Timezone, func() {
+		if err := t.windowsAllow(task.Hosts); err != nil {
+			Infof(t.Logger, "Task %s skipped: %s", task.Name, err)
+			return
+		}
+		t.incoming <- task
+	})
+}
+
+// scheduleCron registers the cron by its spec, a suspended cron is removed.
+// Fires outside of the maintenance windows are skipped and recorded. Every fire
+// runs a copy of the cron as it is stored then.
+func (t *TaskHandler) scheduleCron(c *pub.Cron) error {
+	if c.Suspended {
+		t.scheduler.Remove(cronKey(c.ID))
+		return nil
+	}
+	id := c.ID
+	return t.scheduler.Set(cronKey(id), c.Name, c.Spec, c.Timezone, func() {
+		c, err := t.TaskService.Cron(id)
+		if err != nil {
+			Errorf(t.Logger, "Cron #%d, error when loading it to run: %s", id, err)
+			return
+		}
+		if c.Suspended {
+			return
+		}
+		if err := t.cronWindowsAllow(c); err != nil {
+			t.skipCron(c, err.Error())
+			return
+		}
+		t.runCronLocked(c, func() {
+			run := c.Run(t)
+			if c.Err != nil {
+				Errorf(t.Logger, "Cron %s, error: %s", c.Name, c.Err.Error())
+			} else {
+				Infof(t.Logger, "Cron %s, output: %s", c.Name, c.Output)
+			}
+			t.finishCron(c)
+			t.saveCronRun(c, run)
+		})
+	})
+}
+
+// runCronLocked calls fn under the lock of the cron's overlap policy, a skipped fire is recorded.
+func (t *TaskHandler) runCronLocked(c *pub.Cron, fn func()) {
+	if !t.locks.run(cronKey(c.ID), c.Overlap, fn) {
+		t.skipCron(c, "still running")
+	}
+}
+
+func (t *TaskHandler) skipCron(c *pub.Cron, reason string) {
+	Infof(t.Logger, "Cron %s skipped: %s", c.Name, reason)
+	now := time.Now()
+	t.saveCronRun(c, &pub.CronRun{CronID: c.ID, Started: now, Finished: now, Skipped: true, ExitStatus: -1, Err: "Skipped: " + reason})
+}
+
+// cronWindowsAllow checks the windows of the hosts of a remote cron, only the global
+// windows apply to the other crons.
+func (t *TaskHandler) cronWindowsAllow(c *pub.Cron) error {
+	if !c.IsRemote() {
+		return t.windowsAllow(nil)
+	}
+	hosts, err := t.cronHosts(c)
+	if err != nil {
+		return err
+	}
+	return t.windowsAllow(hosts)
+}
+
+// url: /scheduler/entries  method: GET
+func (t *TaskHandler) getSchedulerEntries(ctx *gin.Context) {
+	ctx.IndentedJSON(http.StatusOK, t.scheduler.Entries())
+}
+
+const (
+	defaultPreviewCount = 5
+	maxPreviewCount     = 100
+)
+
+type postPreviewRequest struct {
+	Spec     string `json:"spec" binding:"required"`
+	Timezone string `json:"timezone"`
+	Count    int    `json:"count"`
+}
+
+type previewResponse struct {
+	Spec     string      `json:"spec"`
+	Timezone string      `json:"timezone"`
+	Next     []time.Time `json:"next"`
+}
+
+// url: /scheduler/preview  method: POST  body: postPreviewRequest
+// the next `count` fire times of the spec in `timezone`, the server's one by default.
+func (t *TaskHandler) previewSchedule(ctx *gin.Context) {
+	var req postPreviewRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	schedule, err := parseSpec(req.Spec)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	loc, err := loadLocation(req.Timezone)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if req.Count <= 0 {
+		req.Count = defaultPreviewCount
+	} else if req.Count > maxPreviewCount {
+		req.Count = maxPreviewCount
+	}
+	resp := &previewResponse{Spec: req.Spec, Timezone: loc.String()}
+	next := time.Now().In(loc)
+	for i := 0; i < req.Count; i++ {
+		next = schedule.Next(next)
+		if next.IsZero() {
+			break
+		}
+		resp.Next = append(resp.Next, next)
+	}
+	ctx.IndentedJSON(http.StatusOK, resp)
+}
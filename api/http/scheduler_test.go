@@ -0,0 +1,70 @@
+package http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerCompact(t *testing.T) {
+	s := newScheduler()
+	fired := make(chan struct{}, 1)
+	if err := s.Set("cron.1", "kept", "@every 1s", "UTC", func() {
+		select {
+		case fired <- struct{}{}:
+		default:
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*maxDisabled; i++ {
+		spec := "@every 1h"
+		if i%2 == 1 {
+			spec = "@every 2h"
+		}
+		if err := s.Set("cron.2", "replaced", spec, "UTC", func() {}); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			s.Remove("cron.2")
+		}
+	}
+	if n := len(s.cron.Entries()); n > maxDisabled+2 {
+		t.Errorf("the cron holds %d entries, want at most %d", n, maxDisabled+2)
+	}
+	if e := s.Entry("cron.2"); e == nil || e.Spec != "@every 2h" {
+		t.Errorf("cron.2 = %v, want the last spec", e)
+	}
+	select {
+	case <-fired:
+	case <-time.After(3 * time.Second):
+		t.Error("the kept entry does not fire after the rebuilds")
+	}
+}
+
+func TestEntryScheduleResume(t *testing.T) {
+	s := newScheduler()
+	if err := s.Set("task.1", "t", "@every 1h", "UTC", func() {}); err != nil {
+		t.Fatal(err)
+	}
+	e := s.entries["task.1"]
+	now := time.Now().Truncate(time.Second)
+	resume := now.Add(10 * time.Minute)
+	for _, c := range []struct {
+		name     string
+		schedule entrySchedule
+		t        time.Time
+		want     time.Time
+	}{
+		{"resumes at its previous fire time", entrySchedule{e: e, resume: resume}, now, resume},
+		{"follows its spec after the resume", entrySchedule{e: e, resume: resume}, resume, resume.Add(time.Hour)},
+		{"no resume", entrySchedule{e: e}, now, e.schedule.Next(now)},
+	} {
+		if got := c.schedule.Next(c.t); !got.Equal(c.want) {
+			t.Errorf("%s: Next = %s, want %s", c.name, got, c.want)
+		}
+	}
+	s.Remove("task.1")
+	if got := (entrySchedule{e: e, resume: resume}).Next(now); !got.IsZero() {
+		t.Errorf("a removed entry fires at %s", got)
+	}
+}
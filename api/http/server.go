@@ -6,15 +6,21 @@ import (
 )
 
 type Server struct {
-	Flags         *pub.CliFlags
-	Logger        logger
-	CryptoService pub.CryptoService
-	JWTService    pub.JWTService
-	UserService   pub.UserService
-	HostService   pub.HostService
-	MailerService pub.MailerService
-	TaskService   pub.TaskService
-	ModuleService pub.ModuleService
+	Flags           *pub.CliFlags
+	Logger          logger
+	CryptoService   pub.CryptoService
+	JWTService      pub.JWTService
+	UserService     pub.UserService
+	HostService     pub.HostService
+	MailerService   pub.MailerService
+	TaskService     pub.TaskService
+	ModuleService   pub.ModuleService
+	PolicyService   pub.PolicyService
+	CommandChecker  pub.CommandChecker
+	WorkflowService pub.WorkflowService
+	TemplateService pub.TemplateService
+	WindowService   pub.WindowService
+	WindowChecker   pub.WindowChecker
 }
 
 func (s *Server) Start() error {
@@ -28,9 +34,12 @@ func (s *Server) Start() error {
 	auth := &AuthHandler{Logger: s.Logger, CryptoService: s.CryptoService, JWTService: s.JWTService, UserService: s.UserService}
 	user := &UserHandler{Logger: s.Logger, CryptoService: s.CryptoService, JWTService: s.JWTService, UserService: s.UserService}
 	host := &HostHandler{Logger: s.Logger, HostService: s.HostService}
-	mailer := newMailerHandler(s.UserService, s.MailerService, s.Flags)
-	task := newTaskHandler(s.Logger, s.HostService, s.TaskService, s.Flags)
+	mailer := newMailerHandler(s.Logger, s.UserService, s.MailerService, s.Flags)
+	task := newTaskHandler(s.Logger, s.HostService, s.TaskService, s.WorkflowService, s.CommandChecker, s.WindowChecker, mailer, s.Flags)
 	modules := &ModuleHandler{Logger: s.Logger, ModuleService: s.ModuleService}
+	policy := &PolicyHandler{Logger: s.Logger, PolicyService: s.PolicyService, CommandChecker: s.CommandChecker}
+	window := &WindowHandler{Logger: s.Logger, WindowService: s.WindowService}
+	templates := &TemplateHandler{Logger: s.Logger, TemplateService: s.TemplateService, Task: task}
 	api := app.Group(*s.Flags.ApiPrefix)
 	{
 		api.PUT("/users", user.createUser)
@@ -50,23 +59,73 @@ func (s *Server) Start() error {
 		api.GET("/hostgroups/pk/:id", jwtAuth, host.getHostgroupByID)
 		api.DELETE("/hostgroups/pk/:id", jwtAuth, jwtAdmin, host.deleteHostgroupByID)
 		api.PUT("/mailer", jwtAuth, mailer.createEmail)
-		api.GET("/mailer/:uuid", mailer.getEmailDetail)
+		api.GET("/mailer", jwtAuth, mailer.getEmails)
+		api.GET("/mailer/:uuid", jwtAuth, mailer.getEmailDetail)
+		api.GET("/mailqueue", jwtAuth, jwtAdmin, mailer.getMailQueue)
+		api.DELETE("/mailqueue", jwtAuth, jwtAdmin, mailer.purgeMailQueue)
+		api.DELETE("/mailqueue/:id", jwtAuth, jwtAdmin, mailer.purgeEmail)
+		api.POST("/mailqueue/:id/resend", jwtAuth, jwtAdmin, mwRequireJSON, mailer.resendEmail)
+		api.POST("/mailer/profiles/:name/test", jwtAuth, jwtAdmin, mwRequireJSON, mailer.testSMTPProfile)
+		api.PUT("/mailprofiles", jwtAuth, jwtAdmin, mailer.createSMTPProfile)
+		api.GET("/mailprofiles", jwtAuth, jwtAdmin, mailer.getSMTPProfiles)
+		api.GET("/mailprofiles/:id", jwtAuth, jwtAdmin, mailer.getSMTPProfileByID)
+		api.POST("/mailprofiles/:id", jwtAuth, jwtAdmin, mailer.updateSMTPProfileByID)
+		api.DELETE("/mailprofiles/:id", jwtAuth, jwtAdmin, mailer.deleteSMTPProfileByID)
+		api.PUT("/mailtemplates", jwtAuth, jwtAdmin, mailer.createEmailTemplate)
+		api.GET("/mailtemplates", jwtAuth, mailer.getEmailTemplates)
+		api.GET("/mailtemplates/:id", jwtAuth, mailer.getEmailTemplateByID)
+		api.POST("/mailtemplates/:id", jwtAuth, jwtAdmin, mailer.updateEmailTemplateByID)
+		api.DELETE("/mailtemplates/:id", jwtAuth, jwtAdmin, mailer.deleteEmailTemplateByID)
+		api.POST("/mailtemplates/:id/preview", jwtAuth, mwRequireJSON, mailer.previewEmailTemplate)
 		api.PUT("/tasks", jwtAuth, task.createTask)
 		api.GET("/tasks", jwtAuth, task.getTasks)
 		api.GET("/tasks/detail/:id", jwtAuth, task.getTaskByID)
 		api.POST("/tasks/detail/:id", jwtAuth, task.modifyTaskByID)
+		api.POST("/tasks/detail/:id/run", jwtAuth, mwRequireJSON, task.runTaskByID)
+		api.GET("/tasks/detail/:id/runs", jwtAuth, task.getTaskRunsByID)
+		api.POST("/tasks/detail/:id/approve", jwtAuth, mwRequireJSON, task.approveTaskByID)
+		api.POST("/tasks/detail/:id/reject", jwtAuth, mwRequireJSON, task.rejectTaskByID)
+		api.GET("/tasks/detail/:id/approvals", jwtAuth, task.getTaskApprovalsByID)
 		api.GET("/tasks/events/:id", task.getTaskEventByID)
-		api.GET("/tasks/active/:id", jwtAuth, jwtAdmin, task.activeTaskByID)
 		api.PUT("/crons", jwtAuth, jwtAdmin, task.createCronJob)
 		api.GET("/crons", jwtAuth, task.getCronJobs)
 		api.GET("/crons/detail/:id", jwtAuth, task.getCronJobByID)
+		api.GET("/crons/detail/:id/runs", jwtAuth, task.getCronRunsByID)
+		api.POST("/crons/detail/:id/run", jwtAuth, jwtAdmin, mwRequireJSON, task.runCronJobByID)
 		api.POST("/crons/detail/:id", jwtAuth, jwtAdmin, task.modifyCronJobByID)
 		api.DELETE("/crons/detail/:id", jwtAuth, jwtAdmin, task.deleteCronJobByID)
+		api.PUT("/workflows", jwtAuth, task.createWorkflow)
+		api.GET("/workflows", jwtAuth, task.getWorkflows)
+		api.GET("/workflows/detail/:id", jwtAuth, task.getWorkflowByID)
+		api.POST("/workflows/detail/:id", jwtAuth, task.updateWorkflowByID)
+		api.DELETE("/workflows/detail/:id", jwtAuth, task.deleteWorkflowByID)
+		api.POST("/workflows/detail/:id/run", jwtAuth, mwRequireJSON, task.runWorkflowByID)
+		api.GET("/workflows/detail/:id/runs", jwtAuth, task.getWorkflowRunsByID)
+		api.PUT("/templates", jwtAuth, jwtAdmin, templates.createTemplate)
+		api.GET("/templates", jwtAuth, templates.getTemplates)
+		api.GET("/templates/:id", jwtAuth, templates.getTemplateByID)
+		api.POST("/templates/:id", jwtAuth, jwtAdmin, templates.updateTemplateByID)
+		api.DELETE("/templates/:id", jwtAuth, jwtAdmin, templates.deleteTemplateByID)
+		api.GET("/templates/:id/versions", jwtAuth, templates.getTemplateVersions)
+		api.POST("/templates/:id/run", jwtAuth, mwRequireJSON, templates.runTemplate)
+		api.GET("/scheduler/entries", jwtAuth, jwtAdmin, task.getSchedulerEntries)
+		api.POST("/scheduler/preview", jwtAuth, task.previewSchedule)
 		api.PUT("/modules/svn", jwtAuth, jwtAdmin, modules.createSvnInfo)
 		api.GET("/modules/svn", jwtAuth, modules.getSvnInfos)
 		api.GET("/modules/svn/:id", jwtAuth, modules.getSvnByID)
 		api.POST("/modules/svn/:id", jwtAuth, jwtAdmin, modules.updateSvnByID)
 		api.DELETE("/modules/svn/:id", jwtAuth, jwtAdmin, modules.deleteSvnByID)
+		api.PUT("/policies", jwtAuth, jwtAdmin, policy.createPolicy)
+		api.GET("/policies", jwtAuth, jwtAdmin, policy.getPolicies)
+		api.GET("/policies/detail/:id", jwtAuth, jwtAdmin, policy.getPolicyByID)
+		api.POST("/policies/detail/:id", jwtAuth, jwtAdmin, policy.updatePolicyByID)
+		api.DELETE("/policies/detail/:id", jwtAuth, jwtAdmin, policy.deletePolicyByID)
+		api.POST("/policies/check", jwtAuth, jwtAdmin, policy.checkCommand)
+		api.PUT("/windows", jwtAuth, jwtAdmin, window.createWindow)
+		api.GET("/windows", jwtAuth, window.getWindows)
+		api.GET("/windows/detail/:id", jwtAuth, window.getWindowByID)
+		api.POST("/windows/detail/:id", jwtAuth, jwtAdmin, window.updateWindowByID)
+		api.DELETE("/windows/detail/:id", jwtAuth, jwtAdmin, window.deleteWindowByID)
 	}
 	go user.checkAdminExists()
 	return app.Run(*s.Flags.Addr)
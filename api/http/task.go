@@ -7,26 +7,35 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fengxsong/pubmgmt/api"
 	"github.com/fengxsong/pubmgmt/helper"
 	"github.com/fengxsong/pubmgmt/helper/ssh"
 	"github.com/fengxsong/pubmgmt/module"
-	"github.com/robfig/cron"
 	"gopkg.in/gin-gonic/gin.v1"
 )
 
 type TaskHandler struct {
-	Logger      logger
-	HostService pub.HostService
-	TaskService pub.TaskService
-	incoming    chan *pub.Task
-	scheduling  chan *pub.Task
-	cache       *helper.Store
-	cronPool    chan *pub.Cron
-	cron        *cron.Cron
-	events      chan *event
+	Logger          logger
+	HostService     pub.HostService
+	TaskService     pub.TaskService
+	WorkflowService pub.WorkflowService
+	CommandChecker  pub.CommandChecker
+	WindowChecker   pub.WindowChecker
+	Mailer          *MailerHandler
+	becomeRoles     []pub.UserRole
+	httpHosts       []string
+	authDisabled    bool
+	approvalTTL     time.Duration
+	approvals       sync.Mutex
+	incoming        chan *pub.Task
+	workers         chan struct{}
+	cache           *helper.Store
+	scheduler       *scheduler
+	locks           *jobLocks
+	events          chan *event
 }
 
 const (
@@ -35,24 +44,40 @@ const (
 	cronPrefix  = "cron."
 )
 
-func newTaskHandler(l logger, h pub.HostService, t pub.TaskService, flags *pub.CliFlags) *TaskHandler {
+func newTaskHandler(l logger, h pub.HostService, t pub.TaskService, wf pub.WorkflowService, c pub.CommandChecker, w pub.WindowChecker, m *MailerHandler, flags *pub.CliFlags) *TaskHandler {
 	th := &TaskHandler{
-		Logger:      l,
-		HostService: h,
-		TaskService: t,
-		incoming:    make(chan *pub.Task, *flags.QueueSize),
-		scheduling:  make(chan *pub.Task, *flags.QueueSize),
-		cache:       helper.NewStore(),
-		cronPool:    make(chan *pub.Cron, *flags.QueueSize),
-		cron:        cron.New(),
-		events:      make(chan *event, *flags.QueueSize*2),
-	}
-	go th.cron.Start()
+		Logger:          l,
+		HostService:     h,
+		TaskService:     t,
+		WorkflowService: wf,
+		CommandChecker:  c,
+		WindowChecker:   w,
+		Mailer:          m,
+		incoming:        make(chan *pub.Task, *flags.QueueSize),
+		workers:         make(chan struct{}, *flags.QueueSize),
+		cache:           helper.NewStore(),
+		scheduler:       newScheduler(),
+		locks:           newJobLocks(),
+		events:          make(chan *event, *flags.QueueSize*2),
+		approvalTTL:     *flags.ApprovalTTL,
+		authDisabled:    *flags.NoAuth,
+	}
+	for _, host := range strings.Split(*flags.WorkflowHosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			th.httpHosts = append(th.httpHosts, host)
+		}
+	}
+	for _, role := range strings.Split(*flags.BecomeRoles, ",") {
+		if r, err := strconv.ParseUint(strings.TrimSpace(role), 10, 64); err == nil {
+			th.becomeRoles = append(th.becomeRoles, pub.UserRole(r))
+		}
+	}
 	go th.initTasksFromStore()
 	go th.process()
 	go th.cacheResult()
 	go th.initCrons()
-	go th.runCron()
+	go th.initWorkflows()
+	go th.expireApprovals()
 	return th
 }
 
@@ -60,91 +85,283 @@ type event struct {
 	task   *pub.Task
 	Done   time.Time              `json:"done"`
 	Result map[string]interface{} `json:"result"`
+	hosts  int
+	failed int
 }
 
 func (t *TaskHandler) initTasksFromStore() {
-	// get tasks that require approval and unfinished.
-	tasks, err := t.TaskService.Tasks(true, true)
+	t.backfillStatus()
+
+	// tasks left running by the restart failed, the queued ones run now.
+	tasks, err := t.TaskService.Tasks(&pub.TaskFilter{Status: []pub.TaskStatus{pub.TaskQueued, pub.TaskRunning}})
 	if err != nil && err != pub.ErrTaskSetEmpty {
-		Infof(t.Logger, "Error when getting tasks require approval: %s", err)
+		Infof(t.Logger, "Error when getting unfinished tasks: %s", err)
 		return
 	}
-	for _, task := range tasks {
-		t.cache.Set(taskPrefix+task.UUID, &task, 0)
+	for i := range tasks {
+		task := &tasks[i]
+		if task.Status == pub.TaskRunning {
+			Infof(t.Logger, "Task %s interrupted by restart", task.Name)
+			t.setStatus(task, pub.TaskFailed)
+		} else if task.Spec == "" {
+			Infof(t.Logger, "Task %s queued before restart, running it", task.Name)
+			t.incoming <- task
+		}
 	}
 
-	// send scheduling tasks into channel `scheduling`
 	tasks, err = t.TaskService.TasksSchedule()
 	if err != nil && err != pub.ErrTaskSetEmpty {
 		Infof(t.Logger, "Error when getting scheduling tasks: %s", err)
 		return
 	}
-	for _, task := range tasks {
-		Infof(t.Logger, "sending scheduled task to scheduling channel: %s\n", task.Name)
-		t.scheduling <- &task
-	}
-	// using the same solution as cron _(:з)∠)_
-	go func() {
-		for {
-			select {
-			case task := <-t.scheduling:
-				if ti := t.cache.Get(taskPrefix + task.Name); ti != nil {
-					task = ti.(*pub.Task)
-					task.Suspended = true
-					t.cache.Delete(taskPrefix + task.Name)
-					if task.Suspended {
-						break
-					}
-				}
-				t.cache.Set(taskPrefix+task.Name, task, 0)
-				t.cron.AddFunc(task.Spec, func() {
-					if task.Suspended {
-						return
-					}
-					t.incoming <- task
-				})
+	for i := range tasks {
+		Infof(t.Logger, "scheduling task: %s\n", tasks[i].Name)
+		if err = t.scheduleTask(&tasks[i]); err != nil {
+			Errorf(t.Logger, "Task %s, error when scheduling: %s", tasks[i].Name, err)
+		}
+	}
+}
+
+// legacyApprovalStatus maps the approval statuses which told the runs of a task, before
+// the task had a status of its own, to the task status.
+var legacyApprovalStatus = map[string]pub.TaskStatus{
+	"running": pub.TaskRunning,
+	"done":    pub.TaskSucceeded,
+	"failed":  pub.TaskFailed,
+}
+
+// backfillStatus gives a status to the tasks stored before tasks had one, so that the
+// status filters and the reload of the unfinished tasks find them, and moves the runs
+// out of their approval statuses.
+func (t *TaskHandler) backfillStatus() {
+	tasks, err := t.TaskService.Tasks(nil)
+	if err != nil {
+		if err != pub.ErrTaskSetEmpty {
+			Infof(t.Logger, "Error when getting tasks to backfill their status: %s", err)
+		}
+		return
+	}
+	for i := range tasks {
+		task := &tasks[i]
+		status, legacy := pub.TaskStatus(""), false
+		if task.Approval != nil {
+			if status, legacy = legacyApprovalStatus[task.Approval.Status]; legacy {
+				task.Approval.Status = pub.ApprovalApproved
 			}
 		}
-	}()
+		if task.Status != "" && !legacy {
+			continue
+		}
+		if task.Status == "" {
+			if !legacy {
+				status = t.legacyStatus(task)
+			}
+			task.Status = status
+		}
+		if err = t.TaskService.UpdateTask(task.ID, task); err != nil {
+			Errorf(t.Logger, "Task %s, error when backfilling its status: %s", task.Name, err)
+		}
+	}
 }
 
-// processing tasks in backgroud.
+// legacyStatus tells the status of a task stored before tasks had one. A one-shot task
+// which is not done was interrupted, and a done one ended as its last run did.
+func (t *TaskHandler) legacyStatus(task *pub.Task) pub.TaskStatus {
+	switch {
+	case awaitingApproval(task):
+		return pub.TaskPendingApproval
+	case task.Approval != nil && (task.Approval.Status == pub.ApprovalRejected || task.Approval.Status == pub.ApprovalExpired):
+		return pub.TaskCancelled
+	case !task.Done.IsZero():
+		if runs, _, err := t.TaskService.TaskRuns(task.ID, 0, 1); err == nil && len(runs) > 0 && runs[0].Err != "" {
+			return pub.TaskFailed
+		}
+		return pub.TaskSucceeded
+	case task.Spec != "":
+		return pub.TaskQueued
+	}
+	return pub.TaskFailed
+}
+
+// processing tasks in backgroud, each task under the lock of its overlap policy.
+// At most QueueSize tasks run at once, every run has a copy of the task of its own
+// loaded from the store, the task fired by the scheduler is shared by all its runs.
 func (t *TaskHandler) process() {
 	for {
 		select {
-		case task := <-t.incoming:
-			Infof(t.Logger, "starting to exec task %s: %s\n", task.Name)
-			evt := &event{
-				task:   task,
-				Result: make(map[string]interface{}),
+		case fired := <-t.incoming:
+			task, err := t.TaskService.Task(fired.ID)
+			if err != nil {
+				Errorf(t.Logger, "Task %s, error when loading it to run: %s", fired.Name, err)
+				continue
 			}
-			for _, host := range task.Hosts {
-				h, err := t.HostService.HostByName(host)
-				if err != nil {
-					evt.Result[host] = pub.ErrHostNotFound
-					continue
-				}
-				if !h.IsActive {
-					evt.Result[host] = pub.ErrHostInactive
-					continue
-				}
-				cli := &ssh.Client{
-					Host:   h,
-					Stdout: bytes.Buffer{},
-					Stderr: bytes.Buffer{},
-				}
+			// the approval may have been renewed since the task was fired.
+			if notApproved(task) {
+				Infof(t.Logger, "Task %s skipped: %s", task.Name, pub.ErrNotApproved)
+				continue
+			}
+			// a task fired while it runs stays running.
+			if task.Status != pub.TaskRunning {
+				t.setStatus(task, pub.TaskQueued)
+			}
+			t.workers <- struct{}{}
+			go func() {
+				defer func() { <-t.workers }()
+				t.runTask(task)
+			}()
+		}
+	}
+}
 
-				err = cli.Connect()
-				if err != nil {
-					evt.Result[host] = err
-				} else {
-					evt.Result[host] = cli.Run(task).String()
+func (t *TaskHandler) runTask(task *pub.Task) {
+	run := &pub.TaskRun{TaskID: task.ID, Started: time.Now()}
+	ok := t.locks.run(taskKey(task.ID), task.Overlap, func() {
+		Infof(t.Logger, "starting to exec task %s\n", task.Name)
+		t.setStatus(task, pub.TaskRunning)
+		evt := &event{
+			task:   task,
+			Result: make(map[string]interface{}),
+		}
+		run.Started = time.Now()
+		run.Result = make(map[string]interface{})
+		results, attempts := t.runOnHosts(task, task.Retry)
+		for host, r := range results {
+			evt.Result[host] = r.value
+			evt.hosts++
+			if r.failed {
+				evt.failed++
+			}
+			if err, ok := r.value.(error); ok {
+				run.Result[host] = err.Error()
+			} else {
+				run.Result[host] = r.value
+			}
+		}
+		run.Attempts = attempts
+		run.Err = attempts[len(attempts)-1].Err
+		evt.Done = time.Now()
+		run.Finished = evt.Done
+		t.events <- evt
+	})
+	if !ok {
+		Infof(t.Logger, "Task %s skipped: still running", task.Name)
+		run.Finished, run.Skipped, run.Err = run.Started, true, "Skipped: still running"
+	}
+	t.saveTaskRun(task, run)
+}
+
+// hostResult is the result of a task on a host, `value` is the error, the result
+// parsed by the module or the result as a string.
+type hostResult struct {
+	value  interface{}
+	stdout string
+	failed bool
+}
+
+// runOnHosts runs the task on its hosts, every retry runs on the hosts whose
+// failure is retryable by the retry policy.
+func (t *TaskHandler) runOnHosts(task *pub.Task, retry *pub.RetryPolicy) (map[string]*hostResult, []pub.RunAttempt) {
+	var parser module.ResultParser
+	if m, ok := module.Modules[task.Module]; ok {
+		parser, _ = m().(module.ResultParser)
+	}
+	var (
+		results  = make(map[string]*hostResult)
+		attempts []pub.RunAttempt
+		hosts    = task.Hosts
+	)
+	become, err := t.becomeMethod(task)
+	if err != nil {
+		now := time.Now()
+		for _, host := range hosts {
+			results[host] = &hostResult{value: err, failed: true}
+		}
+		attempt := pub.RunAttempt{Attempt: 1, Started: now, Finished: now, Hosts: hosts, Class: pub.FailureClass(err, -1), Err: err.Error()}
+		return results, []pub.RunAttempt{attempt}
+	}
+	for {
+		attempt := pub.RunAttempt{Attempt: len(attempts) + 1, Started: time.Now(), Hosts: hosts}
+		var failed, retryable []string
+		for _, host := range hosts {
+			var class string
+			exitStatus := -1
+			r := &hostResult{}
+			result, err := t.runOnHost(host, task, task.Files, become, task.Timeout)
+			if err != nil {
+				r.value = err
+				class = pub.FailureClass(err, exitStatus)
+			} else if parser != nil && result.Err == nil {
+				// the pre and post scripts print what they like, only the module's output is parsed.
+				r.value, r.stdout = parser.Parse(result.Stages["Command"]), result.Stdout
+			} else {
+				r.value, r.stdout = result.String(), result.Stdout
+				if result.Err != nil {
+					exitStatus = result.RC
+					class = pub.FailureClass(result.Err, exitStatus)
 				}
 			}
-			evt.Done = time.Now()
-			t.events <- evt
+			results[host] = r
+			if class == "" {
+				continue
+			}
+			r.failed = true
+			failed = append(failed, host)
+			if attempt.Class == "" {
+				attempt.Class = class
+			}
+			if retry.Retryable(class, exitStatus) {
+				retryable = append(retryable, host)
+			}
+		}
+		attempt.Finished = time.Now()
+		if len(failed) > 0 {
+			attempt.Err = fmt.Sprintf("Failed on %d of %d hosts", len(failed), len(hosts))
 		}
+		attempts = append(attempts, attempt)
+		if len(retryable) == 0 || attempt.Attempt >= retry.MaxAttempts() {
+			return results, attempts
+		}
+		time.Sleep(retry.Delay(attempt.Attempt))
+		hosts = retryable
+	}
+}
+
+func (t *TaskHandler) saveTaskRun(task *pub.Task, run *pub.TaskRun) {
+	if err := t.TaskService.CreateTaskRun(run); err != nil {
+		Errorf(t.Logger, "Task %s, error when saving run: %s", task.Name, err)
+		return
+	}
+	if err := t.TaskService.PruneTaskRuns(task.ID, pub.DefaultTaskRetention); err != nil {
+		Errorf(t.Logger, "Task %s, error when pruning runs: %s", task.Name, err)
+	}
+}
+
+// runOnHost connects to the host and runs cmd over ssh after uploading the files,
+// tasks and remote crons go this way. `become` is the method cmd was wrapped with,
+// `timeout` the seconds each stage may run, the ssh default when 0.
+func (t *TaskHandler) runOnHost(hostname string, cmd module.Command, files []pub.TaskFile, become string, timeout int) (*ssh.Result, error) {
+	h, err := t.HostService.HostByName(hostname)
+	if err != nil {
+		return nil, pub.ErrHostNotFound
+	}
+	if !h.IsActive {
+		return nil, pub.ErrHostInactive
+	}
+	cli := &ssh.Client{
+		Host:    h,
+		Stdout:  bytes.Buffer{},
+		Stderr:  bytes.Buffer{},
+		Become:  become,
+		Timeout: timeout,
+	}
+	for _, f := range files {
+		cli.Files = append(cli.Files, module.File{Name: f.Name, Content: f.Content})
 	}
+	if err = cli.Connect(); err != nil {
+		return nil, err
+	}
+	defer cli.Cleanup()
+	return cli.Run(cmd), nil
 }
 
 // cache results
@@ -152,70 +369,184 @@ func (t *TaskHandler) cacheResult() {
 	for {
 		select {
 		case evt := <-t.events:
-			// is it neccessary to update task's `Done` field?
 			task := evt.task
 			task.Done = time.Now()
-			t.TaskService.UpdateTask(task.ID, task)
+			switch {
+			case evt.failed == 0:
+				t.setStatus(task, pub.TaskSucceeded)
+			case evt.failed < evt.hosts:
+				t.setStatus(task, pub.TaskPartiallyFailed)
+			default:
+				t.setStatus(task, pub.TaskFailed)
+			}
 			t.cache.Set(eventPrefix+task.UUID, evt, 0)
+			if task.Report != "" {
+				t.sendReport(evt)
+			}
 		}
 	}
 }
 
+// sendReport mails the result of a task run to the addresses of its report.
+func (t *TaskHandler) sendReport(evt *event) {
+	if t.Mailer == nil {
+		return
+	}
+	results := make(map[string]string)
+	for host, r := range evt.Result {
+		results[host] = fmt.Sprint(r)
+	}
+	data := struct {
+		Task    *pub.Task
+		Results map[string]string
+	}{evt.task, results}
+	t.Mailer.NotifyAs(evt.task.RequiredUserID, pub.TemplateTaskReport, data, evt.task.Report)
+}
+
+// setStatus saves the new status of the task, and the time it was done when it is set.
+// Only these fields are written, the task may have been modified since it was loaded.
+func (t *TaskHandler) setStatus(task *pub.Task, status pub.TaskStatus) {
+	task.Status = status
+	if err := t.TaskService.UpdateTaskStatus(task.ID, status, task.Done); err != nil {
+		Errorf(t.Logger, "Task %s, error when saving status %s: %s", task.Name, status, err)
+	}
+}
+
 // url: /tasks  method: PUT  body: putTaskRequest
-// using a ugly way to stop or restart the task.. see block 92-103
 func (t *TaskHandler) createTask(ctx *gin.Context) {
 	var req putTaskRequest
 	if err := ctx.BindJSON(&req); err != nil {
 		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
 		return
 	}
-	if req.Spec != "" && len(strings.Split(req.Spec, " ")) != 6 {
-		Error(ctx, pub.Error("Scheduled filed is not a valid crond format"), http.StatusBadRequest, nil)
-		return
-	}
 	tokenData, err := extractTokenDataFromRequestContext(ctx)
 	if err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 		return
 	}
-	m, ok := module.Modules[strings.ToLower(req.Module)]
-	if !ok {
-		Error(ctx, pub.Error(fmt.Sprintf("Module: %s not implement yet", req.Module)), http.StatusBadRequest, nil)
+	task, code, err := t.buildTask(tokenData, &req)
+	if err != nil {
+		t.buildError(ctx, err, code)
 		return
 	}
-	reqModule := m()
-	if err := json.Unmarshal(req.Data, reqModule); err != nil {
-		Error(ctx, err, http.StatusBadRequest, nil)
-		return
+	t.submitTask(ctx, task, req.Override)
+}
+
+// buildError writes an error of buildTask, only internal errors are logged.
+func (t *TaskHandler) buildError(ctx *gin.Context, err error, code int) {
+	if code == http.StatusInternalServerError {
+		Error(ctx, err, code, t.Logger)
+	} else {
+		Error(ctx, err, code, nil)
 	}
-	c, err := module.NewExecCommand(reqModule)
+}
+
+// buildTask validates the request and builds the task of the caller, its commands
+// checked against the policies. The status code tells what went wrong.
+func (t *TaskHandler) buildTask(tokenData *pub.TokenData, req *putTaskRequest) (*pub.Task, int, error) {
+	if req.Spec != "" {
+		if _, err := parseSpec(req.Spec); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	if _, err := loadLocation(req.Timezone); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if !validOverlap(req.Overlap) {
+		return nil, http.StatusBadRequest, pub.ErrOverlap
+	}
+	if req.Retry != nil {
+		if err := req.Retry.Validate(); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	if req.Approval != nil {
+		if err := req.Approval.Validate(); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	if req.Report != "" && t.Mailer != nil {
+		if code, err := t.Mailer.policy.checkRecipients(req.Report); err != nil {
+			return nil, code, err
+		}
+	}
+	reqModule, c, err := buildModule(req.Module, req.Data)
 	if err != nil {
-		Error(ctx, err, http.StatusBadRequest, nil)
-		return
+		return nil, http.StatusBadRequest, err
+	}
+	if (req.Become || c.Become) && !t.canBecome(tokenData.Role) {
+		return nil, http.StatusForbidden, pub.ErrBecomeDenied
 	}
 	task := &pub.Task{
 		Name:             req.Name + time.Now().Format(".2006-01-02|15:04:05"),
 		RequiredUserID:   tokenData.ID,
+		Module:           reqModule.Name(),
 		Command:          c.Strings(),
 		PreScript:        req.PreScript,
 		PostScript:       req.PostScript,
 		Created:          time.Now(),
 		Spec:             req.Spec,
+		Timezone:         req.Timezone,
+		Overlap:          req.Overlap,
+		Retry:            req.Retry,
 		UUID:             helper.NewUUID().String(),
 		Comment:          req.Comment,
 		RequiredApproval: req.RequiredApproval,
 		Hosts:            req.Hosts,
+		Become:           req.Become,
+		BecomeUser:       req.BecomeUser,
+		BecomeMethod:     req.BecomeMethod,
+		Report:           req.Report,
+		Files:            taskFiles(c.Files),
+		Timeout:          c.Timeout,
+	}
+	escalate(task, c.Escalation)
+	if err = t.checkCommands(tokenData.Role, task, c.Sources); err != nil {
+		if _, ok := err.(*pub.PolicyViolation); ok {
+			return nil, http.StatusForbidden, err
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	if req.RequiredApproval || req.Approval != nil {
+		policy := pub.ApprovalPolicy{}
+		if req.Approval != nil {
+			policy = *req.Approval
+		}
+		task.RequiredApproval = true
+		task.Approval = pub.NewApproval(policy, task.Created, t.approvalTTL)
+	}
+	return task, http.StatusOK, nil
+}
+
+// submitTask stores the task, then runs or schedules it unless it requires approval,
+// the response has been written when it returns.
+func (t *TaskHandler) submitTask(ctx *gin.Context, task *pub.Task, override string) {
+	var err error
+	if !task.RequiredApproval && task.Spec == "" {
+		// scheduled tasks and tasks waiting for approval are checked when they run.
+		var o *pub.WindowOverride
+		if o, err = t.checkWindows(ctx, task.Hosts, override); err != nil {
+			return
+		}
+		addOverride(task, o)
+	}
+	task.Status = pub.TaskQueued
+	if task.RequiredApproval {
+		task.Status = pub.TaskPendingApproval
 	}
 	if err = t.TaskService.CreateTask(task); err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 		return
 	}
-	if req.RequiredApproval {
-		t.cache.Set(taskPrefix+task.UUID, task, 0)
-		ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: fmt.Sprintf("Task required approval, check %s/tasks/active/%s", ctx.Request.Host, taskPrefix+task.UUID)})
+	if task.RequiredApproval {
+		go t.notifyApprovers(task, ctx.Request.Host)
+		ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: fmt.Sprintf("Task required approval, check %s/tasks/detail/%d/approvals", ctx.Request.Host, task.ID)})
 	} else {
-		if req.Spec != "" {
-			t.scheduling <- task
+		if task.Spec != "" {
+			if err = t.scheduleTask(task); err != nil {
+				Error(ctx, err, http.StatusBadRequest, nil)
+				return
+			}
 		} else {
 			t.incoming <- task
 		}
@@ -223,23 +554,182 @@ func (t *TaskHandler) createTask(ctx *gin.Context) {
 	}
 }
 
+// buildModule decodes data into the module and builds its command.
+func buildModule(name string, data json.RawMessage) (module.Module, *module.ExecCommand, error) {
+	m, ok := module.Modules[strings.ToLower(name)]
+	if !ok {
+		return nil, nil, pub.Error(fmt.Sprintf("Module: %s not implement yet", name))
+	}
+	reqModule := m()
+	if err := json.Unmarshal(data, reqModule); err != nil {
+		return nil, nil, err
+	}
+	c, err := module.NewExecCommand(reqModule)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reqModule, c, nil
+}
+
+// escalate gives the escalation of the module to the task, so that the commands are
+// wrapped once by Task.Strings and the become prompt is answered from the task.
+func escalate(task *pub.Task, e module.Escalation) {
+	if !e.Become {
+		return
+	}
+	task.Become = true
+	if task.BecomeUser == "" {
+		task.BecomeUser = e.BecomeUser
+	}
+	if task.BecomeMethod == "" {
+		task.BecomeMethod = e.BecomeMethod
+	}
+}
+
+// becomeMethod returns how the task becomes another user, empty when it does not.
+// The role of its owner is checked again as it may have changed since the task was created.
+func (t *TaskHandler) becomeMethod(task *pub.Task) (string, error) {
+	if !task.Become {
+		return "", nil
+	}
+	if !t.authDisabled {
+		owner, err := t.Mailer.UserService.User(task.RequiredUserID)
+		if err != nil || !t.canBecome(owner.Role) {
+			return "", pub.ErrBecomeDenied
+		}
+	}
+	if task.BecomeMethod == "" {
+		return "sudo", nil
+	}
+	return task.BecomeMethod, nil
+}
+
+// taskFiles returns the files of a module command, to be stored with the task.
+func taskFiles(files []module.File) []pub.TaskFile {
+	var taskFiles []pub.TaskFile
+	for _, f := range files {
+		taskFiles = append(taskFiles, pub.TaskFile{Name: f.Name, Content: f.Content})
+	}
+	return taskFiles
+}
+
+func (t *TaskHandler) canBecome(role pub.UserRole) bool {
+	for _, r := range t.becomeRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// hostgroupIDs returns the hostgroup of every known host.
+func (t *TaskHandler) hostgroupIDs(hosts []string) ([]uint64, error) {
+	var hostgroupIDs []uint64
+	for _, hostname := range hosts {
+		host, err := t.HostService.HostByName(hostname)
+		if err == pub.ErrHostNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		hostgroupIDs = append(hostgroupIDs, host.HostgroupID)
+	}
+	return hostgroupIDs, nil
+}
+
+// checkCommands checks every stage of the task and the module sources against
+// the command policies of the hostgroups its hosts belong to. A host which is not
+// known fails the check, the policies of its hostgroup can not be told.
+func (t *TaskHandler) checkCommands(role pub.UserRole, task *pub.Task, sources []string) error {
+	var hostgroupIDs []uint64
+	for _, hostname := range task.Hosts {
+		host, err := t.HostService.HostByName(hostname)
+		if err == pub.ErrHostNotFound {
+			return &pub.PolicyViolation{Host: hostname, Reason: "the host is not known"}
+		} else if err != nil {
+			return err
+		}
+		hostgroupIDs = append(hostgroupIDs, host.HostgroupID)
+	}
+	// the become wrapper is pubmgmt's own, whether the role may become is checked apart.
+	for _, c := range task.Stages() {
+		if err := t.CommandChecker.Check(role, hostgroupIDs, c[1]); err != nil {
+			return err
+		}
+	}
+	for _, source := range sources {
+		if err := t.CommandChecker.Check(role, hostgroupIDs, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // field `module` must not be empty.
 // field `data` will unmarshal to a predefined module
 type putTaskRequest struct {
-	Name             string          `json:"name"`
-	PreScript        string          `json:"pre_script"`
-	Module           string          `json:"module"`
-	Data             json.RawMessage `json:"data"`
-	PostScript       string          `json:"post_script"`
-	Spec             string          `json:"spec"`
-	Comment          string          `json:"comment"`
-	RequiredApproval bool            `json:"required_approval"`
-	Hosts            []string        `json:"hosts"`
-}
-
-// url: /tasks  method: GET
+	Name             string           `json:"name"`
+	PreScript        string           `json:"pre_script"`
+	Module           string           `json:"module"`
+	Data             json.RawMessage  `json:"data"`
+	PostScript       string           `json:"post_script"`
+	Spec             string           `json:"spec"`
+	Timezone         string           `json:"timezone"`
+	Overlap          string           `json:"overlap"`
+	Retry            *pub.RetryPolicy `json:"retry"`
+	Comment          string           `json:"comment"`
+	RequiredApproval bool             `json:"required_approval"`
+	// Approval is the approval policy of the task, it implies RequiredApproval.
+	Approval     *pub.ApprovalPolicy `json:"approval"`
+	Hosts        []string            `json:"hosts"`
+	Become       bool                `json:"become"`
+	BecomeUser   string              `json:"become_user"`
+	BecomeMethod string              `json:"become_method"`
+	// Override is the reason of an administrator to run regardless of the maintenance windows.
+	Override string `json:"override"`
+	Report   string `json:"report"`
+}
+
+// taskFilter reads the filter of the tasks from the query, `status` is a comma separated
+// list of statuses, `from` and `to` are RFC 3339 times.
+func taskFilter(ctx *gin.Context) (*pub.TaskFilter, error) {
+	filter := &pub.TaskFilter{Name: ctx.Query("name")}
+	if status := ctx.Query("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			s := pub.TaskStatus(strings.TrimSpace(s))
+			if !s.Valid() {
+				return nil, pub.Error(fmt.Sprintf("Unknown task status %q", s))
+			}
+			filter.Status = append(filter.Status, s)
+		}
+	}
+	if owner := ctx.Query("user_id"); owner != "" {
+		id, err := strconv.ParseUint(owner, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidQueryFormat
+		}
+		filter.UserID = id
+	}
+	for param, v := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := ctx.Query(param); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, ErrInvalidQueryFormat
+			}
+			*v = at
+		}
+	}
+	return filter, nil
+}
+
+// url: /tasks?status=:status&user_id=:id&name=:name&from=:time&to=:time  method: GET
 func (t *TaskHandler) getTasks(ctx *gin.Context) {
-	if tasks, err := t.TaskService.Tasks(false, false); err == pub.ErrTaskSetEmpty {
+	filter, err := taskFilter(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if tasks, err := t.TaskService.Tasks(filter); err == pub.ErrTaskSetEmpty {
 		Error(ctx, err, http.StatusNotFound, nil)
 	} else if err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
@@ -260,12 +750,60 @@ func (t *TaskHandler) getTaskByID(ctx *gin.Context) {
 	} else if err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 	} else {
-		ctx.IndentedJSON(http.StatusOK, task)
+		ctx.IndentedJSON(http.StatusOK, &taskDetail{Task: task, Schedule: t.scheduler.Entry(taskKey(task.ID))})
+	}
+}
+
+// taskDetail is a task with its next fire times, in its timezone and in UTC, when it is scheduled.
+type taskDetail struct {
+	*pub.Task
+	Schedule *schedulerEntry `json:"schedule,omitempty"`
+}
+
+// url: /tasks/detail/:id/runs?page=:page&size=:size  method: GET
+// runs of the task, newest first, for its owner or an administrator.
+func (t *TaskHandler) getTaskRunsByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	page, size, err := getPagination(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	task, err := t.TaskService.Task(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrTaskNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if tokenData.Role != pub.AdministratorRole && tokenData.ID != task.RequiredUserID {
+		Error(ctx, pub.ErrResourceAccessDenied, http.StatusForbidden, nil)
+		return
+	}
+	runs, total, err := t.TaskService.TaskRuns(id, (page-1)*size, size)
+	if err == pub.ErrTaskRunEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, &pageResponse{Total: total, Page: page, Size: size, Items: runs})
 	}
 }
 
 // url: /tasks/detail/:id  method: POST
-// use for update task's `Spec` or `Suspended`
+// use for update task's `Spec`, `Timezone`, `Overlap` or `Suspended`,
+// an empty `Timezone` resets the task to the server's timezone. Only the owner or an
+// administrator updates it, and a new `Spec` or `Timezone` has to be approved again.
 func (t *TaskHandler) modifyTaskByID(ctx *gin.Context) {
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
@@ -281,10 +819,30 @@ func (t *TaskHandler) modifyTaskByID(ctx *gin.Context) {
 		Error(ctx, pub.Error("ID is a hidden filed, equal to ID in request uri"), http.StatusBadRequest, nil)
 		return
 	}
-	if req.Spec != "" && len(strings.Split(req.Spec, " ")) != 6 {
-		Error(ctx, pub.Error("Scheduled filed is not a valid crond format"), http.StatusBadRequest, nil)
+	if req.Spec != "" {
+		if _, err := parseSpec(req.Spec); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if req.Timezone != nil {
+		if _, err := loadLocation(*req.Timezone); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if !validOverlap(req.Overlap) {
+		Error(ctx, pub.ErrOverlap, http.StatusBadRequest, nil)
 		return
 	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	// the task is saved under the approvals lock, a decision on it may be going on.
+	t.approvals.Lock()
+	defer t.approvals.Unlock()
 	task, err := t.TaskService.Task(id)
 	if err == pub.ErrObjNotFound {
 		Error(ctx, pub.ErrTaskNotFound, http.StatusNotFound, nil)
@@ -293,27 +851,54 @@ func (t *TaskHandler) modifyTaskByID(ctx *gin.Context) {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 		return
 	}
-	if req.Spec == task.Spec && req.Suspended == task.Suspended {
+	if tokenData.Role != pub.AdministratorRole && tokenData.ID != task.RequiredUserID {
+		Error(ctx, pub.ErrResourceAccessDenied, http.StatusForbidden, nil)
+		return
+	}
+	if req.Spec == task.Spec && req.Suspended == task.Suspended && (req.Timezone == nil || *req.Timezone == task.Timezone) &&
+		(req.Overlap == "" || req.Overlap == task.Overlap) {
 		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "No fields updated"})
 		return
 	}
+	// the approval was given to run the task when it was scheduled to.
+	renew := task.RequiredApproval && (req.Spec != "" && req.Spec != task.Spec || req.Timezone != nil && *req.Timezone != task.Timezone)
+	if req.Overlap != "" {
+		task.Overlap = req.Overlap
+	}
 	if req.Spec != "" {
 		task.Spec = req.Spec
 	}
+	if req.Timezone != nil {
+		task.Timezone = *req.Timezone
+	}
 	task.Suspended = req.Suspended
+	if renew {
+		renewApproval(task, time.Now(), t.approvalTTL)
+	}
 	if err = t.TaskService.UpdateTask(task.ID, task); err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
-	} else {
-		t.scheduling <- task
-		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Update task success"})
+		return
 	}
+	// a task awaiting approval is unscheduled.
+	if err = t.scheduleTask(task); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if renew {
+		go t.notifyApprovers(task, ctx.Request.Host)
+		ctx.IndentedJSON(http.StatusAccepted, &msgResponse{Msg: fmt.Sprintf("Task updated and requires a new approval, check %s/tasks/detail/%d/approvals", ctx.Request.Host, task.ID)})
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Update task success"})
 }
 
-// update task
+// update task, a missing `Timezone` is left unchanged.
 type postTaskRequest struct {
-	ID        uint64 `json:"id"`
-	Spec      string `json:"spec"`
-	Suspended bool   `json:"suspended"`
+	ID        uint64  `json:"id"`
+	Spec      string  `json:"spec"`
+	Timezone  *string `json:"timezone"`
+	Overlap   string  `json:"overlap"`
+	Suspended bool    `json:"suspended"`
 }
 
 // url: /tasks/events/:id  method: GET
@@ -326,63 +911,78 @@ func (t *TaskHandler) getTaskEventByID(ctx *gin.Context) {
 	}
 }
 
-// url: /tasks/active/:id  method: GET
-func (t *TaskHandler) activeTaskByID(ctx *gin.Context) {
-	id := ctx.Param("id")
-	if evt := t.cache.Get(id); evt == nil {
-		Error(ctx, pub.ErrTaskNotFound, http.StatusNotFound, nil)
-	} else {
-		task := evt.(*pub.Task)
-		if task.Spec != "" {
-			t.scheduling <- task
-		} else {
-			t.incoming <- task
-		}
-		t.cache.Delete(id)
-		id := strings.Replace(id, taskPrefix, eventPrefix, -1)
-		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: fmt.Sprintf("Task will execute very soon, check %s/tasks/events/%s for detail later", ctx.Request.Host, id)})
-	}
-}
-
 func (t *TaskHandler) initCrons() {
 	crons, err := t.TaskService.Crons()
 	if err != nil {
 		Infof(t.Logger, "Error when getting crons: %s", err)
 		return
 	}
-	if len(crons) > 0 {
-		for _, c := range crons {
-			t.cronPool <- &c
+	for i := range crons {
+		if err = t.scheduleCron(&crons[i]); err != nil {
+			Errorf(t.Logger, "Cron %s, error when scheduling: %s", crons[i].Name, err)
 		}
 	}
 }
 
-func (t *TaskHandler) runCron() {
-	for {
-		select {
-		case item := <-t.cronPool:
-			if ci := t.cache.Get(cronPrefix + item.Name); ci != nil {
-				c := ci.(*pub.Cron)
-				c.Suspended = true
-				t.cache.Delete(cronPrefix + c.Name)
-				if item.Suspended {
-					break
-				}
+// cronHosts resolves the hosts and hostgroups of a remote cron to hostnames.
+func (t *TaskHandler) cronHosts(c *pub.Cron) ([]string, error) {
+	hosts := append([]string(nil), c.Hosts...)
+	for _, name := range c.Hostgroups {
+		hostgroup, err := t.HostService.HostgroupByName(name)
+		if err != nil {
+			return nil, err
+		}
+		members, err := t.HostService.HostsByHostgroupID(hostgroup.ID)
+		if err == pub.ErrHostSetEmpty {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, h := range members {
+			if !helper.Contains(hosts, h.Hostname) {
+				hosts = append(hosts, h.Hostname)
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// RunRemote runs the command of a remote cron on every host of it, one after another.
+func (t *TaskHandler) RunRemote(c *pub.Cron) ([]pub.CronHostRun, error) {
+	hosts, err := t.cronHosts(c)
+	if err != nil {
+		return nil, err
+	}
+	cmd := &module.ExecCommand{Command: c.Cmd[0], Arguments: c.Cmd[1:]}
+	var runs []pub.CronHostRun
+	for _, host := range hosts {
+		hr := pub.CronHostRun{Host: host}
+		result, err := t.runOnHost(host, cmd, nil, "", 0)
+		if err != nil {
+			hr.ExitStatus, hr.Err, hr.Class = -1, err.Error(), pub.FailureClass(err, -1)
+		} else {
+			hr.ExitStatus, hr.Stdout, hr.Stderr = result.RC, result.Stdout, result.Stderr
+			if result.Err != nil {
+				hr.Err, hr.Class = result.Err.Error(), pub.FailureClass(result.Err, result.RC)
 			}
-			t.cache.Set(cronPrefix+item.Name, item, 0)
-			t.cron.AddFunc(item.Spec, func() {
-				if item.Suspended {
-					return
-				}
-				item.Run()
-				if item.Err != nil {
-					Errorf(t.Logger, "Cron %s, error: %s", item.Name, item.Err.Error())
-				} else {
-					Infof(t.Logger, "Cron %s, output: %s", item.Name, item.Output)
-				}
-				t.TaskService.UpdateCron(item.ID, item)
-			})
 		}
+		runs = append(runs, hr)
+	}
+	return runs, nil
+}
+
+// saveCronRun stores the run and drops the runs beyond the retention of the cron.
+func (t *TaskHandler) saveCronRun(c *pub.Cron, run *pub.CronRun) {
+	if err := t.TaskService.CreateCronRun(run); err != nil {
+		Errorf(t.Logger, "Cron %s, error when saving run: %s", c.Name, err)
+		return
+	}
+	retention := c.Retention
+	if retention <= 0 {
+		retention = pub.DefaultCronRetention
+	}
+	if err := t.TaskService.PruneCronRuns(c.ID, retention); err != nil {
+		Errorf(t.Logger, "Cron %s, error when pruning runs: %s", c.Name, err)
 	}
 }
 
@@ -393,6 +993,33 @@ func (t *TaskHandler) createCronJob(ctx *gin.Context) {
 		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
 		return
 	}
+	if _, err := parseSpec(req.Spec); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if _, err := loadLocation(req.Timezone); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if !validOverlap(req.Overlap) {
+		Error(ctx, pub.ErrOverlap, http.StatusBadRequest, nil)
+		return
+	}
+	if req.Retry != nil {
+		if err := req.Retry.Validate(); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if req.IsRemote() {
+		if len(req.Cmd) == 0 || len(req.Hosts)+len(req.Hostgroups) == 0 {
+			Error(ctx, pub.Error("Remote cron requires cmd and hosts or hostgroups"), http.StatusBadRequest, nil)
+			return
+		}
+		if err := t.checkCronCommand(ctx, &req); err != nil {
+			return
+		}
+	}
 	cron, err := t.TaskService.CronByName(req.Name)
 	if err != nil && err != pub.ErrCronNotFound {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
@@ -406,11 +1033,43 @@ func (t *TaskHandler) createCronJob(ctx *gin.Context) {
 	if err = t.TaskService.CreateCron(&req); err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 	} else {
-		t.cronPool <- &req
+		if err = t.scheduleCron(&req); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
 		ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: "Put cron success"})
 	}
 }
 
+// checkCronCommand checks the command of a remote cron against the command policies,
+// the error has been written to the response already.
+func (t *TaskHandler) checkCronCommand(ctx *gin.Context, c *pub.Cron) error {
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return err
+	}
+	hosts, err := t.cronHosts(c)
+	if err == pub.ErrHostgroupNotFound {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return err
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return err
+	}
+	cmd := &module.ExecCommand{Command: c.Cmd[0], Arguments: c.Cmd[1:]}
+	task := &pub.Task{Command: cmd.Strings(), Hosts: hosts}
+	if err = t.checkCommands(tokenData.Role, task, nil); err != nil {
+		if _, ok := err.(*pub.PolicyViolation); ok {
+			Error(ctx, err, http.StatusForbidden, nil)
+		} else {
+			Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		}
+		return err
+	}
+	return nil
+}
+
 // url: /crons  method: GET
 func (t *TaskHandler) getCronJobs(ctx *gin.Context) {
 	crons, err := t.TaskService.Crons()
@@ -436,11 +1095,18 @@ func (t *TaskHandler) getCronJobByID(ctx *gin.Context) {
 	} else if err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 	} else {
-		ctx.IndentedJSON(http.StatusOK, cron)
+		ctx.IndentedJSON(http.StatusOK, &cronDetail{Cron: cron, Schedule: t.scheduler.Entry(cronKey(cron.ID))})
 	}
 }
 
+// cronDetail is a cron with its next fire times, in its timezone and in UTC, when it is scheduled.
+type cronDetail struct {
+	*pub.Cron
+	Schedule *schedulerEntry `json:"schedule,omitempty"`
+}
+
 // url: /crons/detail/:id  method: POST
+// an empty `Timezone` resets the cron to the server's timezone.
 func (t *TaskHandler) modifyCronJobByID(ctx *gin.Context) {
 	cronID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
@@ -456,9 +1122,17 @@ func (t *TaskHandler) modifyCronJobByID(ctx *gin.Context) {
 		Error(ctx, pub.Error("ID is a hidden filed, equal to ID in request uri"), http.StatusBadRequest, nil)
 		return
 	}
-	if req.Spec != "" && len(strings.Split(req.Spec, " ")) != 6 {
-		Error(ctx, pub.Error("Spec filed is not a valid crond format"), http.StatusBadRequest, nil)
-		return
+	if req.Spec != "" {
+		if _, err := parseSpec(req.Spec); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if req.Timezone != nil {
+		if _, err := loadLocation(*req.Timezone); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
 	}
 	cron, err := t.TaskService.Cron(cronID)
 	if err == pub.ErrCronNotFound {
@@ -468,26 +1142,61 @@ func (t *TaskHandler) modifyCronJobByID(ctx *gin.Context) {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 		return
 	}
-	if req.Spec == cron.Spec && req.Suspended == cron.Suspended {
+	if !validOverlap(req.Overlap) {
+		Error(ctx, pub.ErrOverlap, http.StatusBadRequest, nil)
+		return
+	}
+	if req.Retry != nil {
+		if err = req.Retry.Validate(); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if req.Spec == cron.Spec && req.Suspended == cron.Suspended && req.Alert == nil && req.Retry == nil &&
+		(req.Timezone == nil || *req.Timezone == cron.Timezone) && (req.Overlap == "" || req.Overlap == cron.Overlap) {
 		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "No fields updated"})
 		return
 	}
+	if req.Overlap != "" {
+		cron.Overlap = req.Overlap
+	}
+	if req.Retry != nil {
+		cron.Retry = req.Retry
+	}
 	if req.Spec != "" {
 		cron.Spec = req.Spec
 	}
+	if req.Timezone != nil {
+		cron.Timezone = *req.Timezone
+	}
+	if req.Alert != nil {
+		cron.Alert = req.Alert
+	}
+	if cron.Suspended && !req.Suspended {
+		// resuming starts a new failure streak, or it would be suspended again right away.
+		cron.Fails, cron.Alerted = 0, false
+	}
 	cron.Suspended = req.Suspended
 	if err = t.TaskService.UpdateCron(cron.ID, cron); err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 	} else {
-		t.cronPool <- cron
+		if err = t.scheduleCron(cron); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
 		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Update cron success"})
 	}
 }
 
+// postCronRequest updates a cron, a missing `Timezone` is left unchanged.
 type postCronRequest struct {
-	ID        uint64 `json:"id"`
-	Spec      string `json:"spec"`
-	Suspended bool   `json:"suspended"`
+	ID        uint64           `json:"id"`
+	Spec      string           `json:"spec"`
+	Timezone  *string          `json:"timezone"`
+	Overlap   string           `json:"overlap"`
+	Suspended bool             `json:"suspended"`
+	Alert     *pub.CronAlert   `json:"alert"`
+	Retry     *pub.RetryPolicy `json:"retry"`
 }
 
 // url: /crons/detail/:id  method: DELETE
@@ -508,8 +1217,198 @@ func (t *TaskHandler) deleteCronJobByID(ctx *gin.Context) {
 	if err = t.TaskService.DeleteCron(cron.ID); err != nil {
 		Error(ctx, err, http.StatusInternalServerError, t.Logger)
 	} else {
-		cron.Suspended = true
-		t.cronPool <- cron
+		if err = t.TaskService.PruneCronRuns(cron.ID, 0); err != nil {
+			Errorf(t.Logger, "Cron %s, error when deleting runs: %s", cron.Name, err)
+		}
+		t.scheduler.Remove(cronKey(cron.ID))
 		ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Delete cron success"})
 	}
 }
+
+// url: /crons/detail/:id/runs?page=:page&size=:size  method: GET
+// runs of the cron job, newest first.
+func (t *TaskHandler) getCronRunsByID(ctx *gin.Context) {
+	cronID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	page, size, err := getPagination(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if _, err = t.TaskService.Cron(cronID); err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrCronNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	runs, total, err := t.TaskService.CronRuns(cronID, (page-1)*size, size)
+	if err == pub.ErrCronRunEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, &pageResponse{Total: total, Page: page, Size: size, Items: runs})
+	}
+}
+
+// run a task or a cron job out of band, `dry_run` only reports what would be executed.
+type postRunRequest struct {
+	DryRun   bool   `json:"dry_run"`
+	Override string `json:"override"`
+}
+
+type dryRunHost struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username,omitempty"`
+	Port     string `json:"port,omitempty"`
+	IsActive bool   `json:"is_active"`
+	Err      string `json:"error,omitempty"`
+}
+
+type dryRunResponse struct {
+	Hosts    []dryRunHost `json:"hosts,omitempty"`
+	Commands [][]string   `json:"commands,omitempty"`
+	URL      string       `json:"url,omitempty"`
+}
+
+func (t *TaskHandler) resolveHosts(hostnames []string) []dryRunHost {
+	var hosts []dryRunHost
+	for _, hostname := range hostnames {
+		h, err := t.HostService.HostByName(hostname)
+		if err != nil {
+			hosts = append(hosts, dryRunHost{Hostname: hostname, Err: err.Error()})
+			continue
+		}
+		hosts = append(hosts, dryRunHost{Hostname: h.Hostname, Username: h.Username, Port: h.Port, IsActive: h.IsActive})
+	}
+	return hosts
+}
+
+func bindRunRequest(ctx *gin.Context) (*postRunRequest, error) {
+	var req postRunRequest
+	if ctx.Request.ContentLength == 0 {
+		return &req, nil
+	}
+	if err := ctx.BindJSON(&req); err != nil {
+		return nil, ErrInvalidJSON
+	}
+	return &req, nil
+}
+
+// url: /tasks/detail/:id/run  method: POST  body: postRunRequest
+// execute the task right away, its schedule is left as it is.
+func (t *TaskHandler) runTaskByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	req, err := bindRunRequest(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	task, err := t.TaskService.Task(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrTaskNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if tokenData.Role != pub.AdministratorRole && tokenData.ID != task.RequiredUserID {
+		Error(ctx, pub.ErrResourceAccessDenied, http.StatusForbidden, nil)
+		return
+	}
+	if req.DryRun {
+		ctx.IndentedJSON(http.StatusOK, &dryRunResponse{Hosts: t.resolveHosts(task.Hosts), Commands: task.Strings()})
+		return
+	}
+	if ranApproved(task) {
+		t.reapprove(ctx, task.ID)
+		return
+	}
+	if notApproved(task) {
+		Error(ctx, pub.ErrNotApproved, http.StatusConflict, nil)
+		return
+	}
+	override, err := t.checkWindows(ctx, task.Hosts, req.Override)
+	if err != nil {
+		return
+	}
+	if override != nil {
+		addOverride(task, override)
+		if err = t.TaskService.UpdateTask(task.ID, task); err != nil {
+			Error(ctx, err, http.StatusInternalServerError, t.Logger)
+			return
+		}
+	}
+	t.incoming <- task
+	ctx.IndentedJSON(http.StatusAccepted, &msgResponse{Msg: fmt.Sprintf("Task will execute very soon, check %s/tasks/events/%s for detail later", ctx.Request.Host, eventPrefix+task.UUID)})
+}
+
+// url: /crons/detail/:id/run  method: POST  body: postRunRequest
+// execute the cron job right away, its schedule and alert state are left as they are.
+func (t *TaskHandler) runCronJobByID(ctx *gin.Context) {
+	cronID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	req, err := bindRunRequest(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	cron, err := t.TaskService.Cron(cronID)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrCronNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if req.DryRun {
+		resp := &dryRunResponse{URL: cron.URL}
+		if len(cron.Cmd) > 0 {
+			resp.Commands = (&module.ExecCommand{Command: cron.Cmd[0], Arguments: cron.Cmd[1:]}).Strings()
+		}
+		if cron.IsRemote() {
+			hosts, err := t.cronHosts(cron)
+			if err != nil {
+				Error(ctx, err, http.StatusBadRequest, nil)
+				return
+			}
+			resp.Hosts = t.resolveHosts(hosts)
+		}
+		ctx.IndentedJSON(http.StatusOK, resp)
+		return
+	}
+	// local crons have no hosts, the global windows apply to them.
+	var hosts []string
+	if cron.IsRemote() {
+		if hosts, err = t.cronHosts(cron); err != nil {
+			Error(ctx, err, http.StatusBadRequest, nil)
+			return
+		}
+	}
+	override, err := t.checkWindows(ctx, hosts, req.Override)
+	if err != nil {
+		return
+	}
+	go t.runCronLocked(cron, func() {
+		run := cron.Run(t)
+		run.Override = override
+		t.saveCronRun(cron, run)
+	})
+	ctx.IndentedJSON(http.StatusAccepted, &msgResponse{Msg: fmt.Sprintf("Cron will execute very soon, check %s/crons/detail/%d/runs for detail later", ctx.Request.Host, cron.ID)})
+}
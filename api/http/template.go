@@ -0,0 +1,328 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/helper"
+	"github.com/fengxsong/pubmgmt/module"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// TemplateHandler manages the task templates, a template runs as a task of the TaskHandler.
+type TemplateHandler struct {
+	Logger          logger
+	TemplateService pub.TemplateService
+	Task            *TaskHandler
+}
+
+func validateTemplate(tmpl *pub.TaskTemplate) error {
+	if _, ok := module.Modules[strings.ToLower(tmpl.Module)]; !ok {
+		return pub.Error(fmt.Sprintf("Module: %s not implement yet", tmpl.Module))
+	}
+	if len(tmpl.Data) == 0 {
+		tmpl.Data = []byte("{}")
+	}
+	return tmpl.Validate()
+}
+
+// saveVersion records the template as it is now, by the user of the request.
+func (t *TemplateHandler) saveVersion(tokenData *pub.TokenData, tmpl *pub.TaskTemplate, comment string) error {
+	return t.TemplateService.CreateTemplateVersion(&pub.TemplateVersion{
+		TemplateID: tmpl.ID,
+		Version:    tmpl.Version,
+		UserID:     tokenData.ID,
+		Username:   tokenData.Username,
+		Comment:    comment,
+		Created:    tmpl.Updated,
+		Template:   *tmpl,
+	})
+}
+
+// templateRequest is a template along with the comment of the version it makes.
+type templateRequest struct {
+	pub.TaskTemplate
+	Comment string `json:"comment"`
+}
+
+// url: /templates  method: PUT  body: templateRequest
+func (t *TemplateHandler) createTemplate(ctx *gin.Context) {
+	var req templateRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if err := validateTemplate(&req.TaskTemplate); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if _, err = t.TemplateService.TemplateByName(req.Name); err == nil {
+		Error(ctx, pub.ErrTemplateAlreadyExists, http.StatusConflict, nil)
+		return
+	} else if err != pub.ErrTemplateNotFound {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	tmpl := &req.TaskTemplate
+	tmpl.ID = 0
+	tmpl.Version = 1
+	tmpl.UpdatedBy = tokenData.Username
+	tmpl.Created = time.Now()
+	tmpl.Updated = tmpl.Created
+	if err = t.TemplateService.CreateTemplate(tmpl); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if err = t.saveVersion(tokenData, tmpl, req.Comment); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: "Put task template success"})
+}
+
+// url: /templates  method: GET
+func (t *TemplateHandler) getTemplates(ctx *gin.Context) {
+	templates, err := t.TemplateService.Templates()
+	if err == pub.ErrTemplateSetEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, templates)
+	}
+}
+
+// templateByID returns the template of the `id` param, the error has been written to the response.
+func (t *TemplateHandler) templateByID(ctx *gin.Context) (*pub.TaskTemplate, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return nil, err
+	}
+	tmpl, err := t.TemplateService.Template(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrTemplateNotFound, http.StatusNotFound, nil)
+		return nil, err
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// url: /templates/:id  method: GET
+func (t *TemplateHandler) getTemplateByID(ctx *gin.Context) {
+	if tmpl, err := t.templateByID(ctx); err == nil {
+		ctx.IndentedJSON(http.StatusOK, tmpl)
+	}
+}
+
+// url: /templates/:id  method: POST  body: templateRequest
+func (t *TemplateHandler) updateTemplateByID(ctx *gin.Context) {
+	tmpl, err := t.templateByID(ctx)
+	if err != nil {
+		return
+	}
+	var req templateRequest
+	if err = ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if req.ID == 0 || req.ID != tmpl.ID {
+		Error(ctx, errIDField, http.StatusBadRequest, nil)
+		return
+	}
+	if err = validateTemplate(&req.TaskTemplate); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if req.Name != tmpl.Name {
+		if _, err = t.TemplateService.TemplateByName(req.Name); err == nil {
+			Error(ctx, pub.ErrTemplateAlreadyExists, http.StatusConflict, nil)
+			return
+		} else if err != pub.ErrTemplateNotFound {
+			Error(ctx, err, http.StatusInternalServerError, t.Logger)
+			return
+		}
+	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	updated := &req.TaskTemplate
+	updated.Version = tmpl.Version + 1
+	updated.UpdatedBy = tokenData.Username
+	updated.Created = tmpl.Created
+	updated.Updated = time.Now()
+	if err = t.TemplateService.UpdateTemplate(tmpl.ID, updated); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if err = t.saveVersion(tokenData, updated, req.Comment); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: fmt.Sprintf("Update task template success, version %d", updated.Version)})
+}
+
+// url: /templates/:id  method: DELETE
+func (t *TemplateHandler) deleteTemplateByID(ctx *gin.Context) {
+	tmpl, err := t.templateByID(ctx)
+	if err != nil {
+		return
+	}
+	if err = t.TemplateService.DeleteTemplate(tmpl.ID); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Delete task template success"})
+}
+
+// url: /templates/:id/versions  method: GET
+func (t *TemplateHandler) getTemplateVersions(ctx *gin.Context) {
+	tmpl, err := t.templateByID(ctx)
+	if err != nil {
+		return
+	}
+	versions, err := t.TemplateService.TemplateVersions(tmpl.ID)
+	if err == pub.ErrTemplateVersionEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, versions)
+	}
+}
+
+// runTemplateRequest holds the values of the template variables, `Hosts` replaces
+// the default hosts of the template when given.
+type runTemplateRequest struct {
+	Vars             map[string]interface{} `json:"vars"`
+	Hosts            []string               `json:"hosts"`
+	Spec             string                 `json:"spec"`
+	Timezone         string                 `json:"timezone"`
+	Overlap          string                 `json:"overlap"`
+	Retry            *pub.RetryPolicy       `json:"retry"`
+	Comment          string                 `json:"comment"`
+	RequiredApproval bool                   `json:"required_approval"`
+	Approval         *pub.ApprovalPolicy    `json:"approval"`
+	Become           bool                   `json:"become"`
+	BecomeUser       string                 `json:"become_user"`
+	BecomeMethod     string                 `json:"become_method"`
+	Override         string                 `json:"override"`
+	Report           string                 `json:"report"`
+}
+
+// url: /templates/:id/run  method: POST  body: runTemplateRequest
+func (t *TemplateHandler) runTemplate(ctx *gin.Context) {
+	tmpl, err := t.templateByID(ctx)
+	if err != nil {
+		return
+	}
+	var req runTemplateRequest
+	if err = ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	values, err := tmpl.Values(req.Vars)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	rendered, err := tmpl.Render(values)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	hosts := rendered.Hosts
+	if len(req.Hosts) > 0 {
+		hosts = req.Hosts
+	}
+	hosts, code, err := t.knownHosts(hosts)
+	if err != nil {
+		t.Task.buildError(ctx, err, code)
+		return
+	}
+	comment := req.Comment
+	if comment == "" {
+		comment = fmt.Sprintf("Task template %s, version %d", tmpl.Name, tmpl.Version)
+	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	task, code, err := t.Task.buildTask(tokenData, &putTaskRequest{
+		Name:             tmpl.Name,
+		PreScript:        rendered.PreScript,
+		Module:           rendered.Module,
+		Data:             rendered.Data,
+		PostScript:       rendered.PostScript,
+		Spec:             req.Spec,
+		Timezone:         req.Timezone,
+		Overlap:          req.Overlap,
+		Retry:            req.Retry,
+		Comment:          comment,
+		RequiredApproval: req.RequiredApproval,
+		Approval:         req.Approval,
+		Hosts:            hosts,
+		Become:           req.Become,
+		BecomeUser:       req.BecomeUser,
+		BecomeMethod:     req.BecomeMethod,
+		Report:           req.Report,
+	})
+	if err != nil {
+		t.Task.buildError(ctx, err, code)
+		return
+	}
+	t.Task.submitTask(ctx, task, req.Override)
+}
+
+// knownHosts resolves the hosts a template runs on, every name is a known host or a hostgroup
+// whose hosts are added. The names may come from the values of the variables.
+func (t *TemplateHandler) knownHosts(names []string) ([]string, int, error) {
+	var hosts []string
+	add := func(hostname string) {
+		if !helper.Contains(hosts, hostname) {
+			hosts = append(hosts, hostname)
+		}
+	}
+	for _, name := range names {
+		_, err := t.Task.HostService.HostByName(name)
+		if err == nil {
+			add(name)
+			continue
+		} else if err != pub.ErrHostNotFound {
+			return nil, http.StatusInternalServerError, err
+		}
+		hostgroup, err := t.Task.HostService.HostgroupByName(name)
+		if err == pub.ErrHostgroupNotFound {
+			return nil, http.StatusBadRequest, pub.Error(fmt.Sprintf("Host or hostgroup %s is not known", name))
+		} else if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		members, err := t.Task.HostService.HostsByHostgroupID(hostgroup.ID)
+		if err == pub.ErrHostSetEmpty {
+			continue
+		} else if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		for _, h := range members {
+			add(h.Hostname)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, http.StatusBadRequest, pub.Error("Task template requires hosts")
+	}
+	return hosts, 0, nil
+}
@@ -0,0 +1,191 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/api/window"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+type WindowHandler struct {
+	Logger        logger
+	WindowService pub.WindowService
+}
+
+// url: /windows  method: PUT  body: pub.MaintenanceWindow
+func (w *WindowHandler) createWindow(ctx *gin.Context) {
+	var req pub.MaintenanceWindow
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if err := window.Validate(&req); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	windows, err := w.WindowService.Windows()
+	if err != nil && err != pub.ErrWindowSetEmpty {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+		return
+	}
+	for _, item := range windows {
+		if item.Name == req.Name {
+			Error(ctx, pub.ErrWindowAlreadyExists, http.StatusConflict, nil)
+			return
+		}
+	}
+	req.ID = 0
+	req.Created = time.Now()
+	if err = w.WindowService.CreateWindow(&req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: "Put maintenance window success"})
+}
+
+// url: /windows  method: GET
+func (w *WindowHandler) getWindows(ctx *gin.Context) {
+	windows, err := w.WindowService.Windows()
+	if err == pub.ErrWindowSetEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, windows)
+	}
+}
+
+// windowResponse tells whether the window is open right now.
+type windowResponse struct {
+	*pub.MaintenanceWindow
+	Active bool `json:"active"`
+}
+
+// url: /windows/detail/:id  method: GET
+func (w *WindowHandler) getWindowByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	item, err := w.WindowService.Window(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrWindowNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+		return
+	}
+	active, err := window.Active(item, time.Now())
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &windowResponse{MaintenanceWindow: item, Active: active})
+}
+
+// url: /windows/detail/:id  method: POST  body: pub.MaintenanceWindow
+func (w *WindowHandler) updateWindowByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	var req pub.MaintenanceWindow
+	if err = ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if req.ID == 0 || req.ID != id {
+		Error(ctx, errIDField, http.StatusBadRequest, nil)
+		return
+	}
+	if err = window.Validate(&req); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	item, err := w.WindowService.Window(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrWindowNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+		return
+	}
+	req.Created = item.Created
+	if err = w.WindowService.UpdateWindow(id, &req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Update maintenance window success"})
+}
+
+// url: /windows/detail/:id  method: DELETE
+func (w *WindowHandler) deleteWindowByID(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	if _, err = w.WindowService.Window(id); err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrWindowNotFound, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+		return
+	}
+	if err = w.WindowService.DeleteWindow(id); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, w.Logger)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Delete maintenance window success"})
+}
+
+// windowsAllow checks the maintenance windows of the hostgroups of hosts right now.
+func (t *TaskHandler) windowsAllow(hosts []string) error {
+	hostgroupIDs, err := t.hostgroupIDs(hosts)
+	if err != nil {
+		return err
+	}
+	return t.WindowChecker.Check(hostgroupIDs, time.Now())
+}
+
+// addOverride records the override in the history of the task, nil is no override.
+func addOverride(task *pub.Task, o *pub.WindowOverride) {
+	if o == nil {
+		return
+	}
+	task.Override = o
+	task.Overrides = append(task.Overrides, *o)
+}
+
+// checkWindows checks the maintenance windows of hosts unless an administrator gives
+// a reason to override them, the override is returned to be recorded.
+// The error has been written to the response already.
+func (t *TaskHandler) checkWindows(ctx *gin.Context, hosts []string, reason string) (*pub.WindowOverride, error) {
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return nil, err
+	}
+	if reason != "" {
+		if tokenData.Role != pub.AdministratorRole {
+			Error(ctx, pub.ErrOverrideDenied, http.StatusForbidden, nil)
+			return nil, pub.ErrOverrideDenied
+		}
+		Infof(t.Logger, "User %s overrides maintenance windows: %s", tokenData.Username, reason)
+		return &pub.WindowOverride{UserID: tokenData.ID, Reason: reason, Time: time.Now()}, nil
+	}
+	if err = t.windowsAllow(hosts); err != nil {
+		if _, ok := err.(*pub.WindowViolation); ok {
+			Error(ctx, err, http.StatusForbidden, nil)
+		} else {
+			Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		}
+		return nil, err
+	}
+	return nil, nil
+}
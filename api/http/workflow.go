@@ -0,0 +1,573 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/helper"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+const (
+	workflowPrefix    = "workflow."
+	maxWorkflowOutput = 4096
+)
+
+func workflowKey(id uint64) string { return fmt.Sprintf("%s%d", workflowPrefix, id) }
+
+// workflowContext is the data the steps are rendered with.
+type workflowContext struct {
+	Vars  map[string]string
+	Steps map[string]*pub.WorkflowStepRun
+}
+
+// escaped returns a copy of the context with its strings escaped for json data.
+func (c *workflowContext) escaped() *workflowContext {
+	e := &workflowContext{Vars: make(map[string]string), Steps: make(map[string]*pub.WorkflowStepRun)}
+	for k, v := range c.Vars {
+		e.Vars[k] = pub.JSONEscape(v)
+	}
+	for k, sr := range c.Steps {
+		step := *sr
+		step.Name, step.Status = pub.JSONEscape(sr.Name), pub.JSONEscape(sr.Status)
+		step.Stdout, step.Err = pub.JSONEscape(sr.Stdout), pub.JSONEscape(sr.Err)
+		step.Hosts = make(map[string]string)
+		for host, out := range sr.Hosts {
+			step.Hosts[host] = pub.JSONEscape(out)
+		}
+		e.Steps[k] = &step
+	}
+	return e
+}
+
+func (t *TaskHandler) initWorkflows() {
+	workflows, err := t.WorkflowService.Workflows()
+	if err != nil {
+		Infof(t.Logger, "Error when getting workflows: %s", err)
+		return
+	}
+	for i := range workflows {
+		if err = t.scheduleWorkflow(&workflows[i]); err != nil {
+			Errorf(t.Logger, "Workflow %s, error when scheduling: %s", workflows[i].Name, err)
+		}
+	}
+}
+
+// scheduleWorkflow registers the workflow by its spec, a suspended workflow is removed.
+func (t *TaskHandler) scheduleWorkflow(w *pub.Workflow) error {
+	if w.Spec == "" || w.Suspended {
+		t.scheduler.Remove(workflowKey(w.ID))
+		return nil
+	}
+	return t.scheduler.Set(workflowKey(w.ID), w.Name, w.Spec, w.Timezone, func() {
+		t.runWorkflow(w, nil, nil)
+	})
+}
+
+// runWorkflow runs the workflow under the lock of its overlap policy and returns the record of the run.
+// Steps outside of the maintenance windows fail unless the run has an override.
+func (t *TaskHandler) runWorkflow(w *pub.Workflow, vars map[string]string, override *pub.WindowOverride) *pub.WorkflowRun {
+	run := &pub.WorkflowRun{WorkflowID: w.ID, Started: time.Now(), Override: override, Vars: make(map[string]string)}
+	for k, v := range w.Vars {
+		run.Vars[k] = v
+	}
+	for k, v := range vars {
+		run.Vars[k] = v
+	}
+	ok := t.locks.run(workflowKey(w.ID), w.Overlap, func() {
+		run.Started, run.Status = time.Now(), pub.WorkflowRunning
+		if err := t.WorkflowService.CreateWorkflowRun(run); err != nil {
+			Errorf(t.Logger, "Workflow %s, error when saving run: %s", w.Name, err)
+		}
+		data := &workflowContext{Vars: run.Vars, Steps: make(map[string]*pub.WorkflowStepRun)}
+		failed := false
+		role, err := t.ownerRole(w)
+		if err != nil {
+			failed, run.Err = true, fmt.Sprintf("Owner of the workflow: %s", err)
+		}
+		// the workflow is a DAG, so each step is visited once at most.
+		for step := w.EntryStep(); step != nil && err == nil; {
+			Infof(t.Logger, "Workflow %s, starting step %s", w.Name, step.Name)
+			sr := t.runStep(w, role, step, data, override)
+			run.Steps = append(run.Steps, *sr)
+			data.Steps[step.Name] = sr
+			t.WorkflowService.UpdateWorkflowRun(run.ID, run)
+			next := step.OnSuccess
+			if sr.Status == pub.WorkflowFailed {
+				failed, next = true, step.OnFailure
+				run.Err = fmt.Sprintf("Step %s failed: %s", step.Name, sr.Err)
+			}
+			step = w.Step(next)
+		}
+		run.Status = pub.WorkflowSucceeded
+		if failed {
+			run.Status = pub.WorkflowFailed
+		}
+	})
+	if !ok {
+		Infof(t.Logger, "Workflow %s skipped: still running", w.Name)
+		run.Status, run.Err = pub.WorkflowSkipped, "Skipped: still running"
+	}
+	run.Finished = time.Now()
+	if run.ID == 0 {
+		if err := t.WorkflowService.CreateWorkflowRun(run); err != nil {
+			Errorf(t.Logger, "Workflow %s, error when saving run: %s", w.Name, err)
+		}
+	} else if err := t.WorkflowService.UpdateWorkflowRun(run.ID, run); err != nil {
+		Errorf(t.Logger, "Workflow %s, error when saving run: %s", w.Name, err)
+	}
+	if err := t.WorkflowService.PruneWorkflowRuns(w.ID, pub.DefaultTaskRetention); err != nil {
+		Errorf(t.Logger, "Workflow %s, error when pruning runs: %s", w.Name, err)
+	}
+	return run
+}
+
+// ownerRole returns the role the workflow runs with, the current role of its owner.
+func (t *TaskHandler) ownerRole(w *pub.Workflow) (pub.UserRole, error) {
+	if t.authDisabled {
+		return pub.AdministratorRole, nil
+	}
+	owner, err := t.Mailer.UserService.User(w.UserID)
+	if err == pub.ErrObjNotFound {
+		return 0, pub.ErrUserNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return owner.Role, nil
+}
+
+func (t *TaskHandler) runStep(w *pub.Workflow, role pub.UserRole, step *pub.WorkflowStep, data *workflowContext, override *pub.WindowOverride) *pub.WorkflowStepRun {
+	sr := &pub.WorkflowStepRun{Name: step.Name, Started: time.Now(), Status: pub.WorkflowSucceeded}
+	defer func() { sr.Finished = time.Now() }()
+	fail := func(err error) *pub.WorkflowStepRun {
+		sr.Status, sr.Err = pub.WorkflowFailed, err.Error()
+		return sr
+	}
+	rendered, err := renderStep(step, data)
+	if err != nil {
+		return fail(err)
+	}
+	if rendered.Type == pub.StepHTTP {
+		if !t.allowedURL(role, rendered.URL) {
+			return fail(pub.ErrHTTPStepDenied)
+		}
+		sr.Attempts, sr.Stdout, err = t.requestStep(role, rendered)
+		if err != nil {
+			return fail(err)
+		}
+		return sr
+	}
+	task, sources, err := t.stepTask(w, role, rendered)
+	if err != nil {
+		return fail(err)
+	}
+	if err = t.checkCommands(role, task, sources); err != nil {
+		return fail(err)
+	}
+	if override == nil {
+		if err = t.windowsAllow(task.Hosts); err != nil {
+			return fail(err)
+		}
+	}
+	results, attempts := t.runOnHosts(task, rendered.Retry)
+	sr.Attempts, sr.Hosts = attempts, make(map[string]string)
+	for _, host := range task.Hosts {
+		r := results[host]
+		if err, ok := r.value.(error); ok {
+			sr.Hosts[host] = err.Error()
+		} else {
+			sr.Hosts[host] = helper.Truncate(r.stdout, maxWorkflowOutput)
+			sr.Stdout = strings.TrimSpace(r.stdout)
+		}
+	}
+	sr.Stdout = helper.Truncate(sr.Stdout, maxWorkflowOutput)
+	if err := attempts[len(attempts)-1].Err; err != "" {
+		return fail(pub.Error(err))
+	}
+	return sr
+}
+
+// stepTask builds the task a StepTask runs, with the module sources to check as well.
+func (t *TaskHandler) stepTask(w *pub.Workflow, role pub.UserRole, step *pub.WorkflowStep) (*pub.Task, []string, error) {
+	m, c, err := buildModule(step.Module, step.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.Become && !t.canBecome(role) {
+		return nil, nil, pub.ErrBecomeDenied
+	}
+	task := &pub.Task{
+		Name:           w.Name + "/" + step.Name,
+		RequiredUserID: w.UserID,
+		Module:         m.Name(),
+		Command:        c.Strings(),
+		PreScript:      step.PreScript,
+		PostScript:     step.PostScript,
+		Hosts:          step.Hosts,
+		Files:          taskFiles(c.Files),
+		Timeout:        c.Timeout,
+	}
+	escalate(task, c.Escalation)
+	return task, c.Sources, nil
+}
+
+// renderStep returns a copy of the step with its data, hosts and url rendered,
+// the data is rendered as json like the data of task templates.
+func renderStep(step *pub.WorkflowStep, data *workflowContext) (*pub.WorkflowStep, error) {
+	rendered := *step
+	render := func(text string) (string, error) {
+		if !strings.Contains(text, "{{") {
+			return text, nil
+		}
+		return pub.RenderText(step.Name, text, data)
+	}
+	var err error
+	if strings.Contains(string(step.Data), "{{") {
+		if rendered.Data, err = pub.RenderJSON(step.Name, string(step.Data), data.escaped()); err != nil {
+			return nil, err
+		}
+	}
+	if rendered.URL, err = render(step.URL); err != nil {
+		return nil, err
+	}
+	rendered.Hosts = nil
+	for _, host := range step.Hosts {
+		if host, err = render(host); err != nil {
+			return nil, err
+		}
+		rendered.Hosts = append(rendered.Hosts, host)
+	}
+	return &rendered, nil
+}
+
+// allowedURL reports whether the http steps of a workflow of the role may request the url,
+// administrators request any url and the others the hosts of the allow-list only.
+func (t *TaskHandler) allowedURL(role pub.UserRole, rawurl string) bool {
+	if role == pub.AdministratorRole {
+		return true
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range t.httpHosts {
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// requestStep requests the url of a StepHTTP, retrying it by the retry policy of the step.
+func (t *TaskHandler) requestStep(role pub.UserRole, step *pub.WorkflowStep) ([]pub.RunAttempt, string, error) {
+	var attempts []pub.RunAttempt
+	for {
+		attempt := pub.RunAttempt{Attempt: len(attempts) + 1, Started: time.Now()}
+		body, status, err := t.requestOnce(role, step)
+		attempt.Finished = time.Now()
+		if err == nil {
+			return append(attempts, attempt), body, nil
+		}
+		attempt.Class, attempt.Err = pub.FailureClass(err, status), err.Error()
+		if status > 0 {
+			attempt.Class = pub.RetryExit
+		}
+		attempts = append(attempts, attempt)
+		if attempt.Attempt >= step.Retry.MaxAttempts() || !step.Retry.Retryable(attempt.Class, status) {
+			return attempts, body, err
+		}
+		time.Sleep(step.Retry.Delay(attempt.Attempt))
+	}
+}
+
+// requestOnce requests the url of the step, redirects are followed to allowed urls only.
+func (t *TaskHandler) requestOnce(role pub.UserRole, step *pub.WorkflowStep) (string, int, error) {
+	cli := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("Stopped after 10 redirects")
+		}
+		if !t.allowedURL(role, req.URL.String()) {
+			return pub.ErrHTTPStepDenied
+		}
+		return nil
+	}}
+	sess := helper.NewSession(cli, nil)
+	resp, err := sess.Get(step.URL, nil, nil)
+	if err != nil {
+		return "", -1, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxWorkflowOutput+1))
+	out := helper.Truncate(string(body), maxWorkflowOutput)
+	if step.ExpectStatus != 0 && resp.StatusCode != step.ExpectStatus || step.ExpectStatus == 0 && resp.StatusCode >= 400 {
+		return out, resp.StatusCode, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+	return out, 0, nil
+}
+
+// validateWorkflow checks the workflow, and the commands of the steps which are not templated
+// with the role of its owner, the error has been written to the response already.
+func (t *TaskHandler) validateWorkflow(ctx *gin.Context, w *pub.Workflow) error {
+	err := w.Validate()
+	if err == nil && w.Spec != "" {
+		_, err = parseSpec(w.Spec)
+	}
+	if err == nil {
+		_, err = loadLocation(w.Timezone)
+	}
+	if err == nil && !validOverlap(w.Overlap) {
+		err = pub.ErrOverlap
+	}
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return err
+	}
+	role, err := t.ownerRole(w)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return err
+	}
+	for i := range w.Steps {
+		step := &w.Steps[i]
+		if step.Type == pub.StepHTTP {
+			// templated urls are checked when they are requested.
+			if !strings.Contains(step.URL, "{{") && !t.allowedURL(role, step.URL) {
+				Error(ctx, pub.ErrHTTPStepDenied, http.StatusForbidden, nil)
+				return pub.ErrHTTPStepDenied
+			}
+			continue
+		}
+		if strings.Contains(string(step.Data), "{{") {
+			continue
+		}
+		task, sources, err := t.stepTask(w, role, step)
+		if err == pub.ErrBecomeDenied {
+			Error(ctx, err, http.StatusForbidden, nil)
+			return err
+		} else if err != nil {
+			Error(ctx, pub.Error(fmt.Sprintf("Step %s: %s", step.Name, err)), http.StatusBadRequest, nil)
+			return err
+		}
+		if err = t.checkCommands(role, task, sources); err != nil {
+			if _, ok := err.(*pub.PolicyViolation); ok {
+				Error(ctx, err, http.StatusForbidden, nil)
+			} else {
+				Error(ctx, err, http.StatusInternalServerError, t.Logger)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// url: /workflows  method: PUT  body: pub.Workflow
+func (t *TaskHandler) createWorkflow(ctx *gin.Context) {
+	var req pub.Workflow
+	if err := ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	req.ID, req.UserID = 0, tokenData.ID
+	if err = t.validateWorkflow(ctx, &req); err != nil {
+		return
+	}
+	if _, err = t.WorkflowService.WorkflowByName(req.Name); err == nil {
+		Error(ctx, pub.ErrWorkflowAlreadyExists, http.StatusConflict, nil)
+		return
+	} else if err != pub.ErrWorkflowNotFound {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	req.Created = time.Now()
+	req.Updated = req.Created
+	if err = t.WorkflowService.CreateWorkflow(&req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if err = t.scheduleWorkflow(&req); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	ctx.IndentedJSON(http.StatusCreated, &msgResponse{Msg: "Put workflow success"})
+}
+
+// url: /workflows  method: GET
+// the workflows of the caller, all of them for an administrator.
+func (t *TaskHandler) getWorkflows(ctx *gin.Context) {
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	workflows, err := t.WorkflowService.Workflows()
+	if err != nil && err != pub.ErrWorkflowSetEmpty {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	var owned []pub.Workflow
+	for _, w := range workflows {
+		if tokenData.Role == pub.AdministratorRole || tokenData.ID == w.UserID {
+			owned = append(owned, w)
+		}
+	}
+	if len(owned) == 0 {
+		Error(ctx, pub.ErrWorkflowSetEmpty, http.StatusNotFound, nil)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, owned)
+}
+
+// workflowByID loads the workflow of the request uri, the caller must own it or be an administrator,
+// its variables and the outputs of its runs are not for everybody. The error has been written
+// to the response already.
+func (t *TaskHandler) workflowByID(ctx *gin.Context) (*pub.Workflow, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return nil, err
+	}
+	w, err := t.WorkflowService.Workflow(id)
+	if err == pub.ErrObjNotFound {
+		Error(ctx, pub.ErrWorkflowNotFound, http.StatusNotFound, nil)
+		return nil, err
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return nil, err
+	}
+	tokenData, err := extractTokenDataFromRequestContext(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return nil, err
+	}
+	if tokenData.Role != pub.AdministratorRole && tokenData.ID != w.UserID {
+		Error(ctx, pub.ErrResourceAccessDenied, http.StatusForbidden, nil)
+		return nil, pub.ErrResourceAccessDenied
+	}
+	return w, nil
+}
+
+// url: /workflows/detail/:id  method: GET
+func (t *TaskHandler) getWorkflowByID(ctx *gin.Context) {
+	if w, err := t.workflowByID(ctx); err == nil {
+		ctx.IndentedJSON(http.StatusOK, w)
+	}
+}
+
+// url: /workflows/detail/:id  method: POST  body: pub.Workflow
+// the workflow is replaced as a whole, it keeps its owner and is checked with the role of the owner.
+func (t *TaskHandler) updateWorkflowByID(ctx *gin.Context) {
+	w, err := t.workflowByID(ctx)
+	if err != nil {
+		return
+	}
+	var req pub.Workflow
+	if err = ctx.BindJSON(&req); err != nil {
+		Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+		return
+	}
+	if req.ID == 0 || req.ID != w.ID {
+		Error(ctx, errIDField, http.StatusBadRequest, nil)
+		return
+	}
+	req.UserID, req.Created = w.UserID, w.Created
+	if err = t.validateWorkflow(ctx, &req); err != nil {
+		return
+	}
+	if req.Name != w.Name {
+		if _, err = t.WorkflowService.WorkflowByName(req.Name); err == nil {
+			Error(ctx, pub.ErrWorkflowAlreadyExists, http.StatusConflict, nil)
+			return
+		}
+	}
+	req.Updated = time.Now()
+	if err = t.WorkflowService.UpdateWorkflow(req.ID, &req); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	if err = t.scheduleWorkflow(&req); err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Update workflow success"})
+}
+
+// url: /workflows/detail/:id  method: DELETE
+func (t *TaskHandler) deleteWorkflowByID(ctx *gin.Context) {
+	w, err := t.workflowByID(ctx)
+	if err != nil {
+		return
+	}
+	if err = t.WorkflowService.DeleteWorkflow(w.ID); err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+		return
+	}
+	t.scheduler.Remove(workflowKey(w.ID))
+	if err = t.WorkflowService.PruneWorkflowRuns(w.ID, 0); err != nil {
+		Errorf(t.Logger, "Workflow %s, error when deleting runs: %s", w.Name, err)
+	}
+	ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "Delete workflow success"})
+}
+
+type postWorkflowRunRequest struct {
+	Vars     map[string]string `json:"vars"`
+	Override string            `json:"override"`
+}
+
+// url: /workflows/detail/:id/run  method: POST  body: postWorkflowRunRequest
+// run the workflow right away, `vars` override the variables of the workflow.
+func (t *TaskHandler) runWorkflowByID(ctx *gin.Context) {
+	w, err := t.workflowByID(ctx)
+	if err != nil {
+		return
+	}
+	var req postWorkflowRunRequest
+	if ctx.Request.ContentLength != 0 {
+		if err = ctx.BindJSON(&req); err != nil {
+			Error(ctx, ErrInvalidJSON, http.StatusBadRequest, nil)
+			return
+		}
+	}
+	var override *pub.WindowOverride
+	if req.Override != "" {
+		if override, err = t.checkWindows(ctx, nil, req.Override); err != nil {
+			return
+		}
+	}
+	go t.runWorkflow(w, req.Vars, override)
+	ctx.IndentedJSON(http.StatusAccepted, &msgResponse{Msg: fmt.Sprintf("Workflow will execute very soon, check %s/workflows/detail/%d/runs for detail later", ctx.Request.Host, w.ID)})
+}
+
+// url: /workflows/detail/:id/runs?page=:page&size=:size  method: GET
+// runs of the workflow, newest first.
+func (t *TaskHandler) getWorkflowRunsByID(ctx *gin.Context) {
+	w, err := t.workflowByID(ctx)
+	if err != nil {
+		return
+	}
+	page, size, err := getPagination(ctx)
+	if err != nil {
+		Error(ctx, err, http.StatusBadRequest, nil)
+		return
+	}
+	runs, total, err := t.WorkflowService.WorkflowRuns(w.ID, (page-1)*size, size)
+	if err == pub.ErrWorkflowRunEmpty {
+		Error(ctx, err, http.StatusNotFound, nil)
+	} else if err != nil {
+		Error(ctx, err, http.StatusInternalServerError, t.Logger)
+	} else {
+		ctx.IndentedJSON(http.StatusOK, &pageResponse{Total: total, Page: page, Size: size, Items: runs})
+	}
+}
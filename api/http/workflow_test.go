@@ -0,0 +1,91 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// userStore serves the users by their ID, the other methods of the service are not implemented.
+type userStore struct {
+	pub.UserService
+	users map[uint64]*pub.User
+}
+
+func (s *userStore) User(ID uint64) (*pub.User, error) {
+	if u, ok := s.users[ID]; ok {
+		return u, nil
+	}
+	return nil, pub.ErrObjNotFound
+}
+
+// runStore records the runs of the workflows, the other methods of the service are not implemented.
+type runStore struct {
+	pub.WorkflowService
+}
+
+func (s *runStore) CreateWorkflowRun(run *pub.WorkflowRun) error            { run.ID = 1; return nil }
+func (s *runStore) UpdateWorkflowRun(ID uint64, run *pub.WorkflowRun) error { return nil }
+func (s *runStore) PruneWorkflowRuns(workflowID uint64, keep int) error     { return nil }
+
+func validateWorkflowCode(t *TaskHandler, w *pub.Workflow) int {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/workflows", func(ctx *gin.Context) {
+		if t.validateWorkflow(ctx, w) == nil {
+			ctx.IndentedJSON(http.StatusOK, &msgResponse{Msg: "ok"})
+		}
+	})
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/workflows", nil)
+	r.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWorkflowOwnerRole(t *testing.T) {
+	users := &userStore{users: map[uint64]*pub.User{
+		1: {ID: 1, Role: pub.AdministratorRole},
+		2: {ID: 2, Role: pub.StandardUserRole},
+	}}
+	th := &TaskHandler{
+		Mailer:          &MailerHandler{UserService: users},
+		WorkflowService: &runStore{},
+		httpHosts:       []string{"ci.example.com"},
+		locks:           newJobLocks(),
+	}
+	workflow := func(owner uint64) *pub.Workflow {
+		return &pub.Workflow{
+			Name:   "deploy",
+			UserID: owner,
+			Steps:  []pub.WorkflowStep{{Name: "hook", Type: pub.StepHTTP, URL: "http://internal.example.com/hook"}},
+		}
+	}
+	for _, c := range []struct {
+		name  string
+		owner uint64
+		code  int
+	}{
+		{"administrator", 1, http.StatusOK},
+		{"standard user", 2, http.StatusForbidden},
+		{"deleted owner", 3, http.StatusInternalServerError},
+	} {
+		if code := validateWorkflowCode(th, workflow(c.owner)); code != c.code {
+			t.Errorf("%s: code = %d, want %d", c.name, code, c.code)
+		}
+	}
+
+	// the workflow was saved by an administrator who has been demoted since.
+	w := workflow(1)
+	users.users[1].Role = pub.StandardUserRole
+	run := th.runWorkflow(w, nil, nil)
+	if run.Status != pub.WorkflowFailed || len(run.Steps) != 1 || run.Steps[0].Err != pub.ErrHTTPStepDenied.Error() {
+		t.Errorf("run of a demoted owner = %s %v, want the step denied", run.Status, run.Steps)
+	}
+	delete(users.users, 1)
+	if run := th.runWorkflow(w, nil, nil); run.Status != pub.WorkflowFailed || len(run.Steps) != 0 {
+		t.Errorf("run of a deleted owner = %s %v, want no step run", run.Status, run.Steps)
+	}
+}
@@ -0,0 +1,283 @@
+package policy
+
+import (
+	"path"
+	"strings"
+
+	"github.com/fengxsong/pubmgmt/helper"
+)
+
+// splitCommands breaks a shell command line into simple commands, each of
+// them as an argv. Command substitutions and `sh -c` payloads are parsed as
+// commands of their own, wrappers like sudo or env are stripped off.
+func splitCommands(line string) [][]string {
+	var (
+		commands [][]string
+		argv     []string
+		word     []byte
+		inWord   bool
+		redirect bool
+	)
+	flushWord := func() {
+		if !inWord {
+			return
+		}
+		if redirect {
+			redirect = false
+		} else {
+			argv = append(argv, string(word))
+		}
+		word, inWord = word[:0], false
+	}
+	flushCommand := func() {
+		flushWord()
+		if len(argv) > 0 {
+			commands = append(commands, unwrap(argv)...)
+		}
+		argv = nil
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			i++
+			if line[i] != '\n' {
+				word, inWord = append(word, line[i]), true
+			}
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			word, inWord = append(word, line[i+1:i+1+end]...), true
+			i += end + 1
+		case c == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' && j+1 < len(line) {
+					j++
+				} else if line[j] == '$' && j+1 < len(line) && line[j+1] == '(' {
+					end := matchParen(line, j+1)
+					if j+2 >= len(line) || line[j+2] != '(' {
+						commands = append(commands, splitCommands(line[j+2:end])...)
+					}
+					word = append(word, line[j:end+1]...)
+					j = end
+					continue
+				}
+				word = append(word, line[j])
+			}
+			inWord = true
+			i = j
+		case c == '$' && i+1 < len(line) && line[i+1] == '(':
+			end := matchParen(line, i+1)
+			// an arithmetic expansion `$((...))` runs no command.
+			if i+2 >= len(line) || line[i+2] != '(' {
+				commands = append(commands, splitCommands(line[i+2:end])...)
+			}
+			word, inWord = append(word, line[i:end+1]...), true
+			i = end
+		case c == '`':
+			end := strings.IndexByte(line[i+1:], '`')
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			commands = append(commands, splitCommands(line[i+1:i+1+end])...)
+			// keep the substitution in the word, a command word built from it is not resolvable.
+			word, inWord = append(word, line[i:i+2+end]...), true
+			i += end + 1
+		case c == ';' || c == '&' || c == '|' || c == '\n' || c == '(' || c == ')':
+			flushCommand()
+		case c == '>' || c == '<':
+			// drop redirections together with their target, `2>&1` included.
+			if inWord && isDigits(word) {
+				word, inWord = word[:0], false
+			}
+			flushWord()
+			for i+1 < len(line) && (line[i+1] == '>' || line[i+1] == '&' || line[i+1] == '<') {
+				i++
+			}
+			redirect = true
+		case c == ' ' || c == '\t' || c == '\r':
+			flushWord()
+		case c == '#' && !inWord:
+			for i < len(line) && line[i] != '\n' {
+				i++
+			}
+			flushCommand()
+		default:
+			word, inWord = append(word, c), true
+		}
+	}
+	flushCommand()
+	return commands
+}
+
+func matchParen(line string, open int) int {
+	depth := 0
+	for i := open; i < len(line); i++ {
+		switch line[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(line) - 1
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// wrappers which run their arguments as a new command,
+// the value is the set of options taking an argument.
+var wrappers = map[string]string{
+	"sudo":    "ugpChUrtTD",
+	"env":     "uC",
+	"nohup":   "",
+	"nice":    "n",
+	"ionice":  "cnp",
+	"time":    "",
+	"timeout": "sk",
+	"command": "",
+	"exec":    "a",
+	"xargs":   "aEIiLlnPs",
+	"chroot":  "",
+	"su":      "cgGs",
+}
+
+var shells = []string{"sh", "bash", "dash", "zsh", "ksh", "ash"}
+
+var keywords = []string{"{", "}", "!", "if", "then", "elif", "else", "fi", "while", "until", "do", "done"}
+
+// unwrap strips environment assignments, shell keywords and wrapper programs off argv.
+func unwrap(argv []string) [][]string {
+	for len(argv) > 0 && (isAssignment(argv[0]) || helper.Contains(keywords, argv[0])) {
+		argv = argv[1:]
+	}
+	if len(argv) == 0 {
+		return nil
+	}
+	program := path.Base(argv[0])
+	for _, sh := range shells {
+		if program != sh {
+			continue
+		}
+		for i := 1; i < len(argv)-1; i++ {
+			if strings.HasPrefix(argv[i], "-") && strings.Contains(argv[i], "c") {
+				return append([][]string{argv}, splitCommands(argv[i+1])...)
+			}
+		}
+	}
+	if program == "find" {
+		for i, arg := range argv {
+			if arg == "-exec" || arg == "-execdir" || arg == "-ok" || arg == "-okdir" {
+				end := i + 1
+				for end < len(argv) && argv[end] != ";" && argv[end] != "+" {
+					end++
+				}
+				return append([][]string{argv}, unwrap(argv[i+1:end])...)
+			}
+		}
+	}
+	opts, ok := wrappers[program]
+	if !ok {
+		return [][]string{argv}
+	}
+	i := 1
+	for ; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if program == "su" && (arg == "-c" || arg == "--command") && i+1 < len(argv) {
+			return append([][]string{argv}, splitCommands(argv[i+1])...)
+		}
+		if program == "env" && isAssignment(arg) {
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			if program == "su" {
+				// the user, su runs a command of -c only.
+				continue
+			}
+			if program == "timeout" || program == "chroot" {
+				// the first positional argument is the duration or the new root.
+				program = ""
+				continue
+			}
+			break
+		}
+		if len(arg) == 2 && strings.IndexByte(opts, arg[1]) >= 0 {
+			i++
+		}
+	}
+	if i >= len(argv) {
+		return [][]string{argv}
+	}
+	return append([][]string{argv}, unwrap(argv[i:])...)
+}
+
+// unresolvable tells why the program argv runs can not be known before the
+// command runs, it is empty when it can.
+func unresolvable(argv []string) string {
+	if strings.ContainsAny(argv[0], "$`") {
+		return "the command is a parameter expansion or a command substitution"
+	}
+	if argv[0] != "[" && argv[0] != "[[" && strings.ContainsAny(argv[0], "*?[") {
+		return "the command is a glob pattern"
+	}
+	program := path.Base(argv[0])
+	if program == "eval" {
+		return "eval runs commands built at run time"
+	}
+	if !helper.Contains(shells, program) {
+		return ""
+	}
+	for i := 1; i < len(argv); i++ {
+		arg := argv[i]
+		switch {
+		case arg == "-" || arg == "-s":
+			return "the shell reads its commands from stdin"
+		case arg == "--":
+			if i+1 < len(argv) {
+				return ""
+			}
+		case arg == "-o" || arg == "+o":
+			i++
+		case !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "+"):
+			// a script file, its body is checked by the module which uploads it.
+			return ""
+		case !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c"):
+			if i == len(argv)-1 {
+				return "the shell has no commands to run"
+			}
+			return ""
+		}
+	}
+	return "the shell reads its commands from stdin"
+}
+
+func isAssignment(s string) bool {
+	eq := strings.IndexByte(s, '=')
+	if eq <= 0 {
+		return false
+	}
+	for _, c := range s[:eq] {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
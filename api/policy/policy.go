@@ -0,0 +1,156 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/helper"
+)
+
+// Service checks commands against the policies kept in PolicyService.
+// Policies are evaluated by ascending priority and the first matching one
+// decides, a command matched by no policy at all is denied.
+type Service struct {
+	PolicyService pub.PolicyService
+}
+
+// defaultPolicies are created when the policy bucket is empty, they deny what
+// the old `dangerCommands` filter used to and allow a few read-only programs,
+// every other command is denied until a policy allows it.
+var defaultPolicies = []pub.CommandPolicy{
+	{Name: "deny-rm", Priority: 10, Action: pub.PolicyDeny, Program: "rm"},
+	{Name: "deny-dd", Priority: 10, Action: pub.PolicyDeny, Program: "dd"},
+	{Name: "deny-mkfs", Priority: 10, Action: pub.PolicyDeny, Regex: `^mkfs(\.\w+)?( |$)`},
+	{Name: "deny-find-delete", Priority: 10, Action: pub.PolicyDeny, Program: "find", Args: []string{"-delete"}},
+	{Name: "deny-reboot", Priority: 10, Action: pub.PolicyDeny, Program: "reboot"},
+	{Name: "deny-halt", Priority: 10, Action: pub.PolicyDeny, Program: "halt"},
+	{Name: "deny-poweroff", Priority: 10, Action: pub.PolicyDeny, Program: "poweroff"},
+	{Name: "deny-shutdown", Priority: 10, Action: pub.PolicyDeny, Program: "shutdown"},
+	{Name: "deny-init", Priority: 10, Action: pub.PolicyDeny, Program: "init"},
+	{Name: "allow-uptime", Priority: 100, Action: pub.PolicyAllow, Program: "uptime"},
+	{Name: "allow-df", Priority: 100, Action: pub.PolicyAllow, Program: "df"},
+	{Name: "allow-free", Priority: 100, Action: pub.PolicyAllow, Program: "free"},
+	{Name: "allow-uname", Priority: 100, Action: pub.PolicyAllow, Program: "uname"},
+	{Name: "allow-hostname", Priority: 100, Action: pub.PolicyAllow, Program: "hostname", Regex: `^\S+$`},
+	{Name: "allow-whoami", Priority: 100, Action: pub.PolicyAllow, Program: "whoami"},
+}
+
+// legacyAllowAll is the allow-all policy older versions shipped, which turned the
+// policies into a deny-list.
+var legacyAllowAll = pub.CommandPolicy{Name: "allow-all", Priority: 1000, Action: pub.PolicyAllow, Comment: "remove it to turn the policies into a strict allow-list"}
+
+// Init creates the default policies when there is none yet, and deletes the
+// allow-all policy of older versions unless it was edited.
+func (s *Service) Init() error {
+	policies, err := s.PolicyService.Policies()
+	if err == nil {
+		for _, p := range policies {
+			if isLegacyAllowAll(&p) {
+				if err = s.PolicyService.DeletePolicy(p.ID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	} else if err != pub.ErrPolicySetEmpty {
+		return err
+	}
+	for i := range defaultPolicies {
+		p := defaultPolicies[i]
+		if err = s.PolicyService.CreatePolicy(&p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isLegacyAllowAll(p *pub.CommandPolicy) bool {
+	return p.Name == legacyAllowAll.Name && p.Priority == legacyAllowAll.Priority &&
+		p.Action == legacyAllowAll.Action && p.Comment == legacyAllowAll.Comment &&
+		p.Role == 0 && p.HostgroupID == 0 && p.Program == "" && len(p.Args) == 0 && p.Regex == ""
+}
+
+// Validate checks that the policy can be evaluated.
+func Validate(p *pub.CommandPolicy) error {
+	if p.Action != pub.PolicyAllow && p.Action != pub.PolicyDeny {
+		return pub.Error(fmt.Sprintf("Policy action must be %s or %s", pub.PolicyAllow, pub.PolicyDeny))
+	}
+	if p.Regex != "" {
+		if _, err := regexp.Compile(p.Regex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check evaluates every simple command of line for the role on each of the hostgroups,
+// a rejection is reported as *pub.PolicyViolation.
+func (s *Service) Check(role pub.UserRole, hostgroupIDs []uint64, line string) error {
+	policies, err := s.PolicyService.Policies()
+	if err != nil && err != pub.ErrPolicySetEmpty {
+		return err
+	}
+	sort.SliceStable(policies, func(i, j int) bool { return policies[i].Priority < policies[j].Priority })
+	if len(hostgroupIDs) == 0 {
+		hostgroupIDs = []uint64{0}
+	}
+	for _, argv := range splitCommands(line) {
+		if reason := unresolvable(argv); reason != "" {
+			return &pub.PolicyViolation{Command: strings.Join(argv, " "), Reason: reason}
+		}
+		for _, hostgroupID := range hostgroupIDs {
+			if err = evaluate(policies, role, hostgroupID, argv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func evaluate(policies []pub.CommandPolicy, role pub.UserRole, hostgroupID uint64, argv []string) error {
+	command := strings.Join(argv, " ")
+	for i := range policies {
+		p := &policies[i]
+		if p.Role != 0 && p.Role != role {
+			continue
+		}
+		if p.HostgroupID != 0 && p.HostgroupID != hostgroupID {
+			continue
+		}
+		ok, err := match(p, argv, command)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if p.Action == pub.PolicyDeny {
+			return &pub.PolicyViolation{Policy: p, Command: command}
+		}
+		return nil
+	}
+	return &pub.PolicyViolation{Command: command}
+}
+
+func match(p *pub.CommandPolicy, argv []string, command string) (bool, error) {
+	if p.Program != "" && p.Program != "*" && p.Program != path.Base(argv[0]) {
+		return false, nil
+	}
+	for _, arg := range p.Args {
+		if !helper.Contains(argv[1:], arg) {
+			return false, nil
+		}
+	}
+	if p.Regex != "" {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(command), nil
+	}
+	return true, nil
+}
@@ -0,0 +1,176 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fengxsong/pubmgmt/api"
+)
+
+// policyStore keeps the policies in memory.
+type policyStore struct {
+	policies []pub.CommandPolicy
+}
+
+func (s *policyStore) Policy(ID uint64) (*pub.CommandPolicy, error) {
+	for i := range s.policies {
+		if s.policies[i].ID == ID {
+			return &s.policies[i], nil
+		}
+	}
+	return nil, pub.ErrPolicyNotFound
+}
+
+func (s *policyStore) Policies() ([]pub.CommandPolicy, error) {
+	if len(s.policies) == 0 {
+		return nil, pub.ErrPolicySetEmpty
+	}
+	return append([]pub.CommandPolicy(nil), s.policies...), nil
+}
+
+func (s *policyStore) UpdatePolicy(ID uint64, policy *pub.CommandPolicy) error {
+	for i := range s.policies {
+		if s.policies[i].ID == ID {
+			s.policies[i] = *policy
+			return nil
+		}
+	}
+	return pub.ErrPolicyNotFound
+}
+
+func (s *policyStore) CreatePolicy(policy *pub.CommandPolicy) error {
+	policy.ID = uint64(len(s.policies) + 1)
+	s.policies = append(s.policies, *policy)
+	return nil
+}
+
+func (s *policyStore) DeletePolicy(ID uint64) error {
+	for i := range s.policies {
+		if s.policies[i].ID == ID {
+			s.policies = append(s.policies[:i], s.policies[i+1:]...)
+			return nil
+		}
+	}
+	return pub.ErrPolicyNotFound
+}
+
+func TestSplitCommands(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		want [][]string
+	}{
+		{"ls -l /tmp", [][]string{{"ls", "-l", "/tmp"}}},
+		{"cd /tmp && ls; pwd | wc -l", [][]string{{"cd", "/tmp"}, {"ls"}, {"pwd"}, {"wc", "-l"}}},
+		{`echo 'a; rm -rf /' "b c"`, [][]string{{"echo", "a; rm -rf /", "b c"}}},
+		{"r\\m -rf /", [][]string{{"rm", "-rf", "/"}}},
+		{"ls >/dev/null 2>&1", [][]string{{"ls"}}},
+		{"FOO=1 sudo -u root rm -rf /", [][]string{{"sudo", "-u", "root", "rm", "-rf", "/"}, {"rm", "-rf", "/"}}},
+		{"env A=1 nice -n 5 rm x", [][]string{{"env", "A=1", "nice", "-n", "5", "rm", "x"}, {"nice", "-n", "5", "rm", "x"}, {"rm", "x"}}},
+		{`sh -c "rm -rf /"`, [][]string{{"sh", "-c", "rm -rf /"}, {"rm", "-rf", "/"}}},
+		{`su - root -c 'reboot'`, [][]string{{"su", "-", "root", "-c", "reboot"}, {"reboot"}}},
+		{"echo $(rm -rf /)", [][]string{{"rm", "-rf", "/"}, {"echo", "$(rm -rf /)"}}},
+		{"echo `reboot`", [][]string{{"reboot"}, {"echo", "`reboot`"}}},
+		{"find / -name x -exec rm {} ;", [][]string{{"find", "/", "-name", "x", "-exec", "rm", "{}"}, {"rm", "{}"}}},
+		{"timeout 10 rm x", [][]string{{"timeout", "10", "rm", "x"}, {"rm", "x"}}},
+		{"if true; then reboot; fi", [][]string{{"true"}, {"reboot"}}},
+		{"ls # ; reboot", [][]string{{"ls"}}},
+		{"i=$((i+1)); [ $i -gt 3 ]", [][]string{{"[", "$i", "-gt", "3", "]"}}},
+		{`echo "$((1+2)) $(reboot)"`, [][]string{{"reboot"}, {"echo", "$((1+2)) $(reboot)"}}},
+	} {
+		if got := splitCommands(c.line); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitCommands(%q) = %q, want %q", c.line, got, c.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	store := &policyStore{}
+	s := &Service{PolicyService: store}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []pub.CommandPolicy{
+		{Name: "allow-ls", Priority: 100, Action: pub.PolicyAllow, Program: "ls"},
+		{Name: "allow-echo", Priority: 100, Action: pub.PolicyAllow, Program: "echo"},
+		{Name: "allow-sh", Priority: 100, Action: pub.PolicyAllow, Regex: `^(ba)?sh `},
+		{Name: "allow-sudo", Priority: 100, Action: pub.PolicyAllow, Program: "sudo"},
+		{Name: "allow-test", Priority: 100, Action: pub.PolicyAllow, Program: "["},
+		{Name: "allow-admin-systemctl", Priority: 100, Action: pub.PolicyAllow, Role: pub.AdministratorRole, Program: "systemctl"},
+		{Name: "deny-stop-sshd", Priority: 50, Action: pub.PolicyDeny, Program: "systemctl", Args: []string{"stop", "sshd"}},
+		{Name: "deny-group-2", Priority: 50, Action: pub.PolicyDeny, HostgroupID: 2, Program: "ls"},
+	} {
+		p := p
+		store.CreatePolicy(&p)
+	}
+	for _, c := range []struct {
+		line       string
+		role       pub.UserRole
+		hostgroups []uint64
+		allowed    bool
+	}{
+		{"ls -l", pub.StandardUserRole, nil, true},
+		{"uptime", pub.StandardUserRole, nil, true},
+		{"cat /etc/shadow", pub.StandardUserRole, nil, false},
+		{"ls; rm -rf /", pub.StandardUserRole, nil, false},
+		{"sudo rm -rf /", pub.StandardUserRole, nil, false},
+		{`sh -c "ls && reboot"`, pub.StandardUserRole, nil, false},
+		{`sh -c 'ls -l'`, pub.StandardUserRole, nil, true},
+		{"echo $(reboot)", pub.StandardUserRole, nil, false},
+		{"ls", pub.StandardUserRole, []uint64{1, 2}, false},
+		{"systemctl restart nginx", pub.StandardUserRole, nil, false},
+		{"systemctl restart nginx", pub.AdministratorRole, nil, true},
+		{"systemctl stop 'sshd'", pub.AdministratorRole, nil, false},
+		{"hostname", pub.StandardUserRole, nil, true},
+		{"[ -d /tmp ] && ls", pub.StandardUserRole, nil, true},
+		{"hostname evil", pub.StandardUserRole, nil, false},
+		// bypasses through commands only known at run time.
+		{"c=rm; $c -rf /", pub.StandardUserRole, nil, false},
+		{`c=rm; "${c}" -rf /`, pub.StandardUserRole, nil, false},
+		{"r$x -rf /", pub.StandardUserRole, nil, false},
+		{"$(echo rm) -rf /", pub.StandardUserRole, nil, false},
+		{"`echo rm` -rf /", pub.StandardUserRole, nil, false},
+		{"sudo $c -rf /", pub.StandardUserRole, nil, false},
+		{`eval "r""m -rf /"`, pub.StandardUserRole, nil, false},
+		{`x='rm -rf /'; sh -c "$x"`, pub.StandardUserRole, nil, false},
+		{`sh -c`, pub.StandardUserRole, nil, false},
+		{"echo rm -rf / | sh", pub.StandardUserRole, nil, false},
+		{"echo rm -rf / | bash -s", pub.StandardUserRole, nil, false},
+		{"sh <<EOF", pub.StandardUserRole, nil, false},
+		{"/bin/r? -rf /", pub.StandardUserRole, nil, false},
+		{"/bin/[r]m -rf /", pub.StandardUserRole, nil, false},
+		{"sh /tmp/pubmgmt/script", pub.StandardUserRole, nil, true},
+	} {
+		err := s.Check(c.role, c.hostgroups, c.line)
+		if c.allowed && err != nil {
+			t.Errorf("Check(%q) = %s, want allowed", c.line, err)
+		} else if !c.allowed {
+			if _, ok := err.(*pub.PolicyViolation); !ok {
+				t.Errorf("Check(%q) = %v, want a policy violation", c.line, err)
+			}
+		}
+	}
+}
+
+func TestInitDeletesLegacyAllowAll(t *testing.T) {
+	edited := legacyAllowAll
+	edited.Role = pub.AdministratorRole
+	store := &policyStore{}
+	store.CreatePolicy(&pub.CommandPolicy{Name: "deny-rm", Priority: 10, Action: pub.PolicyDeny, Program: "rm"})
+	legacy := legacyAllowAll
+	store.CreatePolicy(&legacy)
+	if err := (&Service{PolicyService: store}).Init(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.policies) != 1 || store.policies[0].Name != "deny-rm" {
+		t.Errorf("policies = %v, want the allow-all policy deleted", store.policies)
+	}
+
+	store = &policyStore{}
+	store.CreatePolicy(&edited)
+	if err := (&Service{PolicyService: store}).Init(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.policies) != 1 {
+		t.Errorf("an edited allow-all policy was deleted")
+	}
+}
@@ -1,10 +1,11 @@
 package pub
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/fengxsong/pubmgmt/helper"
-	"github.com/kataras/go-mailer"
 )
 
 type (
@@ -62,23 +63,98 @@ type (
 
 	MailerService interface {
 		CreateEmail(email *Email) error
+		UpdateEmail(ID uint64, email *Email) error
+		DeleteEmail(ID uint64) error
+		Email(ID uint64) (*Email, error)
+		Emails(filter *EmailFilter) ([]Email, error)
+		QueuedEmails() ([]QueuedEmail, error)
+		PruneEmails(before time.Time) (int, error)
+		ScrubEmailConfigs() (int, error)
 		EmailByUser(userId uint64) ([]Email, error)
 		EmailByUUID(uuid string) (*Email, error)
+		EmailTemplate(ID uint64) (*EmailTemplate, error)
+		EmailTemplateByName(name string) (*EmailTemplate, error)
+		EmailTemplates() ([]EmailTemplate, error)
+		CreateEmailTemplate(tmpl *EmailTemplate) error
+		UpdateEmailTemplate(ID uint64, tmpl *EmailTemplate) error
+		DeleteEmailTemplate(ID uint64) error
+		SMTPProfile(ID uint64) (*SMTPProfile, error)
+		SMTPProfileByName(name string) (*SMTPProfile, error)
+		SMTPProfiles() ([]SMTPProfile, error)
+		CreateSMTPProfile(profile *SMTPProfile) error
+		UpdateSMTPProfile(ID uint64, profile *SMTPProfile) error
+		DeleteSMTPProfile(ID uint64) error
 	}
 
 	TaskService interface {
 		Task(ID uint64) (*Task, error)
 		TasksSchedule() ([]Task, error)
-		Tasks(reqApproval, unfinished bool) ([]Task, error)
+		Tasks(filter *TaskFilter) ([]Task, error)
 		UpdateTask(ID uint64, task *Task) error
+		UpdateTaskStatus(ID uint64, status TaskStatus, done time.Time) error
 		CreateTask(task *Task) error
 		DeleteTask(ID uint64) error
 		Cron(ID uint64) (*Cron, error)
 		CronByName(name string) (*Cron, error)
 		Crons() ([]Cron, error)
 		UpdateCron(ID uint64, cron *Cron) error
+		UpdateCronState(ID uint64, update func(cron *Cron)) (*Cron, error)
 		CreateCron(cron *Cron) error
 		DeleteCron(ID uint64) error
+		CronRuns(cronID uint64, offset, limit int) ([]CronRun, int, error)
+		CreateCronRun(run *CronRun) error
+		PruneCronRuns(cronID uint64, keep int) error
+		TaskRuns(taskID uint64, offset, limit int) ([]TaskRun, int, error)
+		CreateTaskRun(run *TaskRun) error
+		PruneTaskRuns(taskID uint64, keep int) error
+	}
+
+	PolicyService interface {
+		Policy(ID uint64) (*CommandPolicy, error)
+		Policies() ([]CommandPolicy, error)
+		UpdatePolicy(ID uint64, policy *CommandPolicy) error
+		CreatePolicy(policy *CommandPolicy) error
+		DeletePolicy(ID uint64) error
+	}
+
+	CommandChecker interface {
+		Check(role UserRole, hostgroupIDs []uint64, command string) error
+	}
+
+	WorkflowService interface {
+		Workflow(ID uint64) (*Workflow, error)
+		WorkflowByName(name string) (*Workflow, error)
+		Workflows() ([]Workflow, error)
+		UpdateWorkflow(ID uint64, workflow *Workflow) error
+		CreateWorkflow(workflow *Workflow) error
+		DeleteWorkflow(ID uint64) error
+		WorkflowRuns(workflowID uint64, offset, limit int) ([]WorkflowRun, int, error)
+		CreateWorkflowRun(run *WorkflowRun) error
+		UpdateWorkflowRun(ID uint64, run *WorkflowRun) error
+		PruneWorkflowRuns(workflowID uint64, keep int) error
+	}
+
+	TemplateService interface {
+		Template(ID uint64) (*TaskTemplate, error)
+		TemplateByName(name string) (*TaskTemplate, error)
+		Templates() ([]TaskTemplate, error)
+		UpdateTemplate(ID uint64, tmpl *TaskTemplate) error
+		CreateTemplate(tmpl *TaskTemplate) error
+		DeleteTemplate(ID uint64) error
+		TemplateVersions(templateID uint64) ([]TemplateVersion, error)
+		CreateTemplateVersion(version *TemplateVersion) error
+	}
+
+	WindowService interface {
+		Window(ID uint64) (*MaintenanceWindow, error)
+		Windows() ([]MaintenanceWindow, error)
+		UpdateWindow(ID uint64, window *MaintenanceWindow) error
+		CreateWindow(window *MaintenanceWindow) error
+		DeleteWindow(ID uint64) error
+	}
+
+	WindowChecker interface {
+		Check(hostgroupIDs []uint64, at time.Time) error
 	}
 
 	ModuleService interface {
@@ -96,23 +172,98 @@ const (
 	StandardUserRole
 )
 
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// Overlap policies tell what happens when a task or a cron fires while it is still running.
+const (
+	OverlapAllow = "allow"
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
+)
+
+// Task statuses, a task waits for approval or is queued, then runs and ends up
+// succeeded, partially failed when some of its hosts failed or failed when all of them did.
+// A task whose approval is rejected or expires is cancelled. The status is the state of
+// the runs of a task, its approval only tells whether it may run.
+const (
+	TaskPendingApproval TaskStatus = "pending_approval"
+	TaskQueued          TaskStatus = "queued"
+	TaskRunning         TaskStatus = "running"
+	TaskSucceeded       TaskStatus = "succeeded"
+	TaskPartiallyFailed TaskStatus = "partially_failed"
+	TaskFailed          TaskStatus = "failed"
+	TaskCancelled       TaskStatus = "cancelled"
+)
+
+// Email statuses, an email is queued when it is accepted and sent, or failed and
+// retried later, until it is dead after the last attempt.
+const (
+	EmailQueued  EmailStatus = "queued"
+	EmailSending EmailStatus = "sending"
+	EmailSent    EmailStatus = "sent"
+	EmailFailed  EmailStatus = "failed"
+	EmailDead    EmailStatus = "dead"
+)
+
+// DefaultTaskRetention is the number of runs kept for each task.
+const DefaultTaskRetention = 100
+
 type (
 	CliFlags struct {
-		Addr       *string
-		NoAuth     *bool
-		ApiPrefix  *string
-		SmtpServer *string
-		Username   *string
-		Password   *string
-		FromAlias  *string
-		MaxRetry   *int
-		QueueSize  *int
-		Data       *string
-		Debug      *bool
+		Addr        *string
+		NoAuth      *bool
+		ApiPrefix   *string
+		SmtpServer  *string
+		Username    *string
+		Password    *string
+		FromAlias   *string
+		MaxRetry    *int
+		QueueSize   *int
+		Data        *string
+		Plugins     *string
+		BecomeRoles *string
+		ApprovalTTL *time.Duration
+		MailBackoff *time.Duration
+		// limits of the emails posted to the relay, 0 is unlimited.
+		MailUserRate      *int
+		MailGlobalRate    *int
+		MailDailyQuota    *int
+		MailMaxRecipients *int
+		MailDomains       *string
+		MailRetention     *time.Duration
+		WorkflowHosts     *string
+		Debug             *bool
 	}
 
 	UserRole uint64
 
+	TaskStatus string
+
+	EmailStatus string
+
+	// EmailFilter selects emails, its zero fields match any email. `From` and `To`
+	// bound the creation time.
+	EmailFilter struct {
+		Status []EmailStatus
+		UserID uint64
+		From   time.Time
+		To     time.Time
+	}
+
+	// TaskFilter selects tasks, its zero fields match any task. `Name` matches a part
+	// of the name and `From` and `To` bound the creation time.
+	TaskFilter struct {
+		Status           []TaskStatus
+		UserID           uint64
+		Name             string
+		From             time.Time
+		To               time.Time
+		RequiredApproval bool
+	}
+
 	User struct {
 		ID       uint64   `json:"id"`
 		Username string   `json:"username" binding:"required,alphanum"`
@@ -143,42 +294,140 @@ type (
 		Password     string `json:"password,omitempty"`
 		HostgroupID  uint64 `json:"hostgroup_id"`
 		IdentityFile string `json:"identity_file,omitempty"`
-		Comment      string `json:"comment"`
-		IsActive     bool   `json:"is_active"`
+		// BecomePassword answers the sudo/su prompt, `Password` is used when it is empty.
+		BecomePassword string `json:"become_password,omitempty"`
+		Comment        string `json:"comment"`
+		IsActive       bool   `json:"is_active"`
 	}
 
+	// Email is sent with a text body `Content`, an html body `HTML` or both. When
+	// `Template` names an email template, the template renders them and the subject with `Data`.
 	Email struct {
-		ID         uint64         `json:"id"`
-		FromUserID uint64         `json:"user_id"`
-		Cfg        *mailer.Config `json:"config,omitempty"`
-		Subject    string         `json:"subject" binding:"required"`
-		Content    string         `json:"content" binding:"required"`
-		Tos        string         `json:"tos" binding:"required"`
-		Created    time.Time      `json:"created"`
-		Done       time.Time      `json:"done"`
-		UUID       string         `json:"uuid"`
-		Err        string         `json:"error"`
-		Sender     mailer.Service `json:"-"`
+		ID          uint64                 `json:"id"`
+		FromUserID  uint64                 `json:"user_id"`
+		Profile     string                 `json:"profile,omitempty"`
+		Subject     string                 `json:"subject"`
+		Content     string                 `json:"content"`
+		HTML        string                 `json:"html,omitempty"`
+		Template    string                 `json:"template,omitempty"`
+		Data        map[string]interface{} `json:"data,omitempty"`
+		Attachments []EmailAttachment      `json:"attachments,omitempty"`
+		Tos         string                 `json:"tos" binding:"required"`
+		Created     time.Time              `json:"created"`
+		Done        time.Time              `json:"done"`
+		UUID        string                 `json:"uuid"`
+		Err         string                 `json:"error"`
+		Status      EmailStatus            `json:"status"`
+		Attempts    int                    `json:"attempts"`
+		// NextAttempt is when a queued or failed email is sent.
+		NextAttempt time.Time `json:"next_attempt"`
+	}
+
+	// QueuedEmail indexes an email waiting to be sent, queued or failed, so that the
+	// queue is read without the emails.
+	QueuedEmail struct {
+		ID          uint64    `json:"id"`
+		Profile     string    `json:"profile,omitempty"`
+		NextAttempt time.Time `json:"next_attempt"`
 	}
 
 	Task struct {
 		ID               uint64     `json:"id"`
 		Name             string     `json:"name"`
 		RequiredUserID   uint64     `json:"user_id,omitempty"`
+		Module           string     `json:"module,omitempty"`
 		PreScript        string     `json:"pre_script,omitempty"`
 		Command          [][]string `json:"command"`
 		PostScript       string     `json:"post_script,omitempty"`
 		Created          time.Time  `json:"created"`
 		Done             time.Time  `json:"done"`
 		Spec             string     `json:"spec,omitempty"`
+		Timezone         string     `json:"timezone,omitempty"`
 		UUID             string     `json:"uuid"`
 		Comment          string     `json:"comment"`
 		RequiredApproval bool       `json:"required_approval"`
 		Suspended        bool       `json:"suspended"`
 		Hosts            []string   `json:"hosts"`
+		Become           bool       `json:"become"`
+		BecomeUser       string     `json:"become_user,omitempty"`
+		BecomeMethod     string     `json:"become_method,omitempty"`
+		// Override is the latest of the Overrides, every one of them is kept.
+		Override  *WindowOverride  `json:"override,omitempty"`
+		Overrides []WindowOverride `json:"overrides,omitempty"`
+		Overlap   string           `json:"overlap,omitempty"`
+		Retry     *RetryPolicy     `json:"retry,omitempty"`
+		Approval  *Approval        `json:"approval,omitempty"`
+		Status    TaskStatus       `json:"status"`
+		// Report is a comma separated list of the addresses the result of the task is mailed to.
+		Report string `json:"report,omitempty"`
+		// Files are uploaded to the hosts before the commands run, e.g. the body of a script.
+		Files []TaskFile `json:"files,omitempty"`
+		// Timeout is the seconds each stage may run on a host, the ssh default when 0.
+		Timeout int `json:"timeout,omitempty"`
+	}
+
+	TaskFile struct {
+		Name    string `json:"name"`
+		Content string `json:"content"`
+	}
+
+	// TaskRun is the record of a single execution of a task, `Result` is keyed by hostname.
+	TaskRun struct {
+		ID       uint64                 `json:"id"`
+		TaskID   uint64                 `json:"task_id"`
+		Started  time.Time              `json:"started"`
+		Finished time.Time              `json:"finished"`
+		Skipped  bool                   `json:"skipped,omitempty"`
+		Err      string                 `json:"error,omitempty"`
+		Result   map[string]interface{} `json:"result,omitempty"`
+		Attempts []RunAttempt           `json:"attempts,omitempty"`
 	}
 )
 
+// CommandPolicy matches a simple command (wrappers like sudo and `sh -c` unfolded).
+// `Program` is compared with the basename of argv[0], every item of `Args` must be
+// present in argv and `Regex` is matched against the whole command.
+// Empty fields, a zero `Role` and a zero `HostgroupID` match everything.
+type CommandPolicy struct {
+	ID          uint64   `json:"id"`
+	Name        string   `json:"name" binding:"required"`
+	Priority    int      `json:"priority"`
+	Action      string   `json:"action" binding:"required"`
+	Role        UserRole `json:"role"`
+	HostgroupID uint64   `json:"hostgroup_id"`
+	Program     string   `json:"program,omitempty"`
+	Args        []string `json:"args,omitempty"`
+	Regex       string   `json:"regex,omitempty"`
+	Comment     string   `json:"comment"`
+}
+
+// PolicyViolation is returned by CommandChecker when a command is rejected,
+// `Policy` is nil when no policy matched the command at all. `Reason` tells why
+// the command, or the commands on `Host`, could not be checked.
+type PolicyViolation struct {
+	Policy  *CommandPolicy
+	Command string
+	Host    string
+	Reason  string
+}
+
+func (v *PolicyViolation) Error() string {
+	if v.Host != "" {
+		return fmt.Sprintf("Commands on host %s can not be checked: %s", v.Host, v.Reason)
+	}
+	if v.Reason != "" {
+		return fmt.Sprintf("Command `%s` can not be checked: %s", v.Command, v.Reason)
+	}
+	if v.Policy == nil {
+		return fmt.Sprintf("Command `%s` is not allowed by any policy", v.Command)
+	}
+	return fmt.Sprintf("Command `%s` is denied by policy #%d (%s)", v.Command, v.Policy.ID, v.Policy.Name)
+}
+
+func (*CommandPolicy) UniqueFields() []string {
+	return []string{"ID", "Name"}
+}
+
 func (*User) UniqueFields() []string {
 	return []string{"ID", "Username", "Email"}
 }
@@ -195,6 +444,19 @@ func (*Email) UniqueFields() []string {
 	return []string{"ID", "UUID"}
 }
 
+func (*QueuedEmail) UniqueFields() []string {
+	return []string{"ID"}
+}
+
+// Waiting reports whether an email with the status waits in the queue to be sent.
+func (s EmailStatus) Waiting() bool {
+	return s == EmailQueued || s == EmailFailed
+}
+
+func (*TaskRun) UniqueFields() []string {
+	return []string{"ID"}
+}
+
 func (*Task) UniqueFields() []string {
 	return []string{"ID", "Name", "UUID"}
 }
@@ -203,17 +465,118 @@ func NewEmail() Email {
 	return Email{
 		Created: time.Now(),
 		UUID:    helper.NewUUID().String(),
+		Status:  EmailQueued,
 	}
 }
 
-func (t *Task) Strings() [][]string {
+// Valid reports whether the status is one of the email statuses.
+func (s EmailStatus) Valid() bool {
+	switch s {
+	case EmailQueued, EmailSending, EmailSent, EmailFailed, EmailDead:
+		return true
+	}
+	return false
+}
+
+// Finished reports whether a task with the status has run and ended.
+func (s TaskStatus) Finished() bool {
+	return s == TaskSucceeded || s == TaskPartiallyFailed || s == TaskFailed
+}
+
+// Valid reports whether the status is one of the task statuses.
+func (s TaskStatus) Valid() bool {
+	switch s {
+	case TaskPendingApproval, TaskQueued, TaskRunning, TaskSucceeded, TaskPartiallyFailed, TaskFailed, TaskCancelled:
+		return true
+	}
+	return false
+}
+
+// Match reports whether the email is selected by the filter, a nil filter selects every email.
+func (f *EmailFilter) Match(e *Email) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Status) > 0 {
+		var matched bool
+		for _, s := range f.Status {
+			matched = matched || s == e.Status
+		}
+		if !matched {
+			return false
+		}
+	}
+	switch {
+	case f.UserID != 0 && f.UserID != e.FromUserID,
+		!f.From.IsZero() && e.Created.Before(f.From),
+		!f.To.IsZero() && e.Created.After(f.To):
+		return false
+	}
+	return true
+}
+
+// Match reports whether the task is selected by the filter, a nil filter selects every task.
+func (f *TaskFilter) Match(t *Task) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Status) > 0 {
+		var matched bool
+		for _, s := range f.Status {
+			matched = matched || s == t.Status
+		}
+		if !matched {
+			return false
+		}
+	}
+	switch {
+	case f.UserID != 0 && f.UserID != t.RequiredUserID,
+		f.Name != "" && !strings.Contains(t.Name, f.Name),
+		!f.From.IsZero() && t.Created.Before(f.From),
+		!f.To.IsZero() && t.Created.After(f.To),
+		f.RequiredApproval && !t.RequiredApproval:
+		return false
+	}
+	return true
+}
+
+// Stages returns the stages of the task as they were given, the commands policies check.
+func (t *Task) Stages() [][]string {
 	var commands [][]string
 	if t.PreScript != "" {
 		commands = append(commands, []string{"PreScript", t.PreScript})
 	}
-	commands = append(commands, t.Command...)
+	for _, c := range t.Command {
+		commands = append(commands, []string{c[0], c[1]})
+	}
 	if t.PostScript != "" {
 		commands = append(commands, []string{"PostScript", t.PostScript})
 	}
 	return commands
 }
+
+// Strings returns the stages of the task, every one wrapped by helper.Become when the
+// task becomes another user, it is the only place commands are wrapped.
+func (t *Task) Strings() [][]string {
+	commands := t.Stages()
+	if t.Become {
+		for i, c := range commands {
+			commands[i] = []string{c[0], helper.Become(t.BecomeMethod, t.BecomeUser, c[1])}
+		}
+	}
+	return commands
+}
+
+// ClearSecrets removes the passwords of the host, before it is returned by the api.
+func (h *Host) ClearSecrets() {
+	h.Password = ""
+	h.BecomePassword = ""
+}
+
+// BecomeSecret is the password answering the privilege escalation prompt.
+func (h *Host) BecomeSecret() string {
+	if h.BecomePassword != "" {
+		return h.BecomePassword
+	}
+	return h.Password
+}
@@ -0,0 +1,157 @@
+package pub
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// Failure classes, a RetryPolicy tells which of them are retried.
+const (
+	// RetryConnect is a host or url that could not be reached.
+	RetryConnect = "connect"
+	// RetryTimeout is a connection or a request that timed out.
+	RetryTimeout = "timeout"
+	// RetryExit is a non zero exit status, or an http status of 400 and above for url crons.
+	RetryExit = "exit"
+	// RetryOther is any other error, like an unknown host, it is only retried when `On` is empty.
+	RetryOther = "other"
+)
+
+const (
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 5 * time.Minute
+)
+
+// RetryPolicy retries a failed run up to `Attempts` attempts in all. The n-th retry
+// waits `Backoff` * 2^(n-1), at most `MaxBackoff`, shortened by up to `Jitter` of it.
+// Only the failures of the `On` classes are retried, every class when empty, and
+// `ExitCodes` limits RetryExit to these exit statuses.
+type RetryPolicy struct {
+	Attempts   int      `json:"attempts"`
+	Backoff    string   `json:"backoff,omitempty"`
+	MaxBackoff string   `json:"max_backoff,omitempty"`
+	Jitter     float64  `json:"jitter,omitempty"`
+	On         []string `json:"on,omitempty"`
+	ExitCodes  []int    `json:"exit_codes,omitempty"`
+}
+
+// RunAttempt is the record of one attempt of a task or a cron run.
+type RunAttempt struct {
+	Attempt  int       `json:"attempt"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Class    string    `json:"class,omitempty"`
+	Err      string    `json:"error,omitempty"`
+	// Hosts are the hosts the attempt ran on, a retry of a task only runs the failed ones.
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+func (p *RetryPolicy) Validate() error {
+	if p.Attempts < 0 {
+		return Error("Retry attempts must not be negative")
+	}
+	for _, d := range []string{p.Backoff, p.MaxBackoff} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v < 0 {
+			return Error(fmt.Sprintf("Invalid retry duration %q", d))
+		}
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return Error("Retry jitter must be between 0 and 1")
+	}
+	for _, class := range p.On {
+		if class != RetryConnect && class != RetryTimeout && class != RetryExit && class != RetryOther {
+			return Error(fmt.Sprintf("Unknown retry class %q", class))
+		}
+	}
+	return nil
+}
+
+// MaxAttempts is the number of attempts in all, 1 at least.
+func (p *RetryPolicy) MaxAttempts() int {
+	if p == nil || p.Attempts < 1 {
+		return 1
+	}
+	return p.Attempts
+}
+
+// Delay is the time to wait before the retry following the given attempt.
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	backoff, max := defaultBackoff, defaultMaxBackoff
+	if v, err := time.ParseDuration(p.Backoff); err == nil && p.Backoff != "" {
+		backoff = v
+	}
+	if v, err := time.ParseDuration(p.MaxBackoff); err == nil && p.MaxBackoff != "" {
+		max = v
+	}
+	d := backoff
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// Retryable reports whether a failure of the class, with the exit status for RetryExit, is retried.
+func (p *RetryPolicy) Retryable(class string, exitStatus int) bool {
+	if p == nil {
+		return false
+	}
+	if class == RetryExit && len(p.ExitCodes) > 0 {
+		var listed bool
+		for _, code := range p.ExitCodes {
+			listed = listed || code == exitStatus
+		}
+		if !listed {
+			return false
+		}
+	}
+	if len(p.On) == 0 {
+		return true
+	}
+	for _, c := range p.On {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// FailureClass classifies the error of a run, a nil error with a non zero exit status is RetryExit.
+func FailureClass(err error, exitStatus int) string {
+	if err == nil {
+		if exitStatus != 0 {
+			return RetryExit
+		}
+		return ""
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return RetryTimeout
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "timed out"):
+		return RetryTimeout
+	case strings.Contains(msg, "connection refused") || strings.Contains(msg, "no route to host") ||
+		strings.Contains(msg, "network is unreachable") || strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "dial tcp"):
+		return RetryConnect
+	}
+	if _, ok := err.(*net.OpError); ok {
+		return RetryConnect
+	}
+	if exitStatus > 0 {
+		return RetryExit
+	}
+	return RetryOther
+}
@@ -0,0 +1,74 @@
+package pub
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/helper"
+)
+
+// SMTPProfile is an smtp server emails are sent through, an email names its
+// profile and the flags of pubmgmt are used without one. `From` is the sender
+// address, `Username` when it is empty. `RateLimit` is the number of emails sent
+// in a minute at most, 0 is unlimited.
+type SMTPProfile struct {
+	ID        uint64    `json:"id"`
+	Name      string    `json:"name" binding:"required"`
+	Host      string    `json:"host" binding:"required"`
+	Port      int       `json:"port" binding:"required"`
+	TLS       string    `json:"tls"`
+	Auth      string    `json:"auth"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password,omitempty"`
+	From      string    `json:"from"`
+	FromAlias string    `json:"from_alias"`
+	RateLimit int       `json:"rate_limit"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+func (*SMTPProfile) UniqueFields() []string {
+	return []string{"ID", "Name"}
+}
+
+// Validate checks the TLS mode, the authentication and the sender of the profile.
+func (p *SMTPProfile) Validate() error {
+	switch p.TLS {
+	case helper.TLSAuto, helper.TLSNone, helper.TLSStartTLS, helper.TLSImplicit:
+	default:
+		return Error(fmt.Sprintf("Unknown TLS mode %q, expected none, starttls or tls", p.TLS))
+	}
+	switch p.Auth {
+	case helper.AuthNone:
+	case helper.AuthPlain, helper.AuthCRAMMD5:
+		if p.Username == "" {
+			return Error("SMTP authentication requires a username")
+		}
+	default:
+		return Error(fmt.Sprintf("Unknown SMTP authentication %q, expected plain or cram-md5", p.Auth))
+	}
+	if p.Port <= 0 || p.Port > 65535 {
+		return Error("SMTP port out of range")
+	}
+	if p.From == "" && p.Username == "" {
+		return Error("SMTP profile requires a from address or a username")
+	}
+	if p.RateLimit < 0 {
+		return Error("Rate limit cannot be negative")
+	}
+	return nil
+}
+
+// Config returns the smtp config of the profile.
+func (p *SMTPProfile) Config() helper.SMTPConfig {
+	return helper.SMTPConfig{
+		Host:      p.Host,
+		Port:      p.Port,
+		TLS:       p.TLS,
+		Auth:      p.Auth,
+		Username:  p.Username,
+		Password:  p.Password,
+		From:      p.From,
+		FromAlias: p.FromAlias,
+	}
+}
@@ -0,0 +1,260 @@
+package pub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/helper"
+)
+
+// Template variable types.
+const (
+	VarString = "string"
+	VarInt    = "int"
+	VarBool   = "bool"
+	VarEnum   = "enum"
+)
+
+var varNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TaskTemplate is a reusable task, its data, scripts and hosts hold `{{.var}}`
+// placeholders of the declared `Vars`. String values are JSON escaped in `Data`,
+// so a placeholder goes between quotes there: `"{{.branch}}"`. They are shell quoted
+// in the scripts, so a placeholder is not put between quotes there: `git checkout {{.branch}}`.
+// The rendered hosts must be known hosts or hostgroups.
+type TaskTemplate struct {
+	ID          uint64          `json:"id"`
+	Name        string          `json:"name" binding:"required"`
+	Description string          `json:"description"`
+	Module      string          `json:"module" binding:"required"`
+	Data        json.RawMessage `json:"data"`
+	PreScript   string          `json:"pre_script,omitempty"`
+	PostScript  string          `json:"post_script,omitempty"`
+	Hosts       []string        `json:"hosts,omitempty"`
+	Vars        []TemplateVar   `json:"vars,omitempty"`
+	Version     int             `json:"version"`
+	UpdatedBy   string          `json:"updated_by"`
+	Created     time.Time       `json:"created"`
+	Updated     time.Time       `json:"updated"`
+}
+
+// TemplateVar declares a variable of a template, `Pattern` restricts a string
+// and `Choices` lists the values of an enum.
+type TemplateVar struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required"`
+	Default     string   `json:"default,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Choices     []string `json:"choices,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// TemplateVersion is a snapshot of a template, taken every time it is saved.
+type TemplateVersion struct {
+	ID         uint64       `json:"id"`
+	TemplateID uint64       `json:"template_id"`
+	Version    int          `json:"version"`
+	UserID     uint64       `json:"user_id"`
+	Username   string       `json:"username"`
+	Comment    string       `json:"comment,omitempty"`
+	Created    time.Time    `json:"created"`
+	Template   TaskTemplate `json:"template"`
+}
+
+func (*TaskTemplate) UniqueFields() []string {
+	return []string{"ID", "Name"}
+}
+
+func (*TemplateVersion) UniqueFields() []string {
+	return []string{"ID"}
+}
+
+// Validate checks the declared variables and their defaults.
+func (t *TaskTemplate) Validate() error {
+	names := make(map[string]bool)
+	for _, v := range t.Vars {
+		if !varNameRegexp.MatchString(v.Name) || names[v.Name] {
+			return Error(fmt.Sprintf("Variable name %q is invalid or duplicated", v.Name))
+		}
+		names[v.Name] = true
+		switch v.Type {
+		case VarString, VarInt, VarBool:
+		case VarEnum:
+			if len(v.Choices) == 0 {
+				return Error(fmt.Sprintf("Variable %s requires choices", v.Name))
+			}
+		default:
+			return Error(fmt.Sprintf("Variable %s: type %s is not supported", v.Name, v.Type))
+		}
+		if v.Pattern != "" {
+			if _, err := regexp.Compile(v.Pattern); err != nil {
+				return Error(fmt.Sprintf("Variable %s: %s", v.Name, err))
+			}
+		}
+		if v.Default != "" {
+			if _, err := v.value(v.Default); err != nil {
+				return err
+			}
+		}
+	}
+	// the placeholders must parse, they are only checked against the vars when rendered.
+	for _, text := range append([]string{string(t.Data), t.PreScript, t.PostScript}, t.Hosts...) {
+		if _, err := template.New(t.Name).Parse(text); err != nil {
+			return Error(fmt.Sprintf("Template %s: %s", t.Name, err))
+		}
+	}
+	return nil
+}
+
+// Values checks the input against the declared variables and returns the typed values,
+// the defaults filling in the missing ones.
+func (t *TaskTemplate) Values(input map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for name := range input {
+		if t.variable(name) == nil {
+			return nil, Error(fmt.Sprintf("Variable %s is not declared", name))
+		}
+	}
+	for _, v := range t.Vars {
+		in, ok := input[v.Name]
+		if !ok || in == nil {
+			if v.Default == "" && v.Required {
+				return nil, Error(fmt.Sprintf("Variable %s is required", v.Name))
+			}
+			in = v.Default
+		}
+		value, err := v.value(in)
+		if err != nil {
+			return nil, err
+		}
+		values[v.Name] = value
+	}
+	return values, nil
+}
+
+func (t *TaskTemplate) variable(name string) *TemplateVar {
+	for i := range t.Vars {
+		if t.Vars[i].Name == name {
+			return &t.Vars[i]
+		}
+	}
+	return nil
+}
+
+// value converts a JSON value, or a default given as a string, to the type of the variable.
+func (v *TemplateVar) value(in interface{}) (interface{}, error) {
+	invalid := Error(fmt.Sprintf("Variable %s must be of type %s", v.Name, v.Type))
+	switch v.Type {
+	case VarInt:
+		switch n := in.(type) {
+		case float64:
+			if n != float64(int64(n)) {
+				return nil, invalid
+			}
+			return int64(n), nil
+		case string:
+			i, err := strconv.ParseInt(n, 10, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			return i, nil
+		}
+	case VarBool:
+		switch b := in.(type) {
+		case bool:
+			return b, nil
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return nil, invalid
+			}
+			return parsed, nil
+		}
+	case VarString, VarEnum:
+		s, ok := in.(string)
+		if !ok {
+			return nil, invalid
+		}
+		if v.Type == VarEnum {
+			for _, choice := range v.Choices {
+				if s == choice {
+					return s, nil
+				}
+			}
+			return nil, Error(fmt.Sprintf("Variable %s must be one of %v", v.Name, v.Choices))
+		}
+		if v.Pattern != "" && !regexp.MustCompile(v.Pattern).MatchString(s) {
+			return nil, Error(fmt.Sprintf("Variable %s does not match %s", v.Name, v.Pattern))
+		}
+		return s, nil
+	}
+	return nil, invalid
+}
+
+// Render returns a copy of the template with the values in place of the placeholders.
+func (t *TaskTemplate) Render(values map[string]interface{}) (*TaskTemplate, error) {
+	escaped, quoted := make(map[string]interface{}), make(map[string]interface{})
+	for k, v := range values {
+		escaped[k], quoted[k] = v, v
+		if s, ok := v.(string); ok {
+			escaped[k], quoted[k] = JSONEscape(s), helper.ShellQuote(s)
+		}
+	}
+	rendered := *t
+	var err error
+	if rendered.Data, err = RenderJSON(t.Name, string(t.Data), escaped); err != nil {
+		return nil, err
+	}
+	if rendered.PreScript, err = RenderText(t.Name, t.PreScript, quoted); err != nil {
+		return nil, err
+	}
+	if rendered.PostScript, err = RenderText(t.Name, t.PostScript, quoted); err != nil {
+		return nil, err
+	}
+	rendered.Hosts = nil
+	for _, host := range t.Hosts {
+		if host, err = RenderText(t.Name, host, values); err != nil {
+			return nil, err
+		}
+		rendered.Hosts = append(rendered.Hosts, host)
+	}
+	return &rendered, nil
+}
+
+// JSONEscape escapes s to be rendered inside of a json string.
+func JSONEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// RenderText executes text as the template `name` with values, a missing key is an error.
+func RenderText(name, text string, values interface{}) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, values); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderJSON renders json data like RenderText, the strings of values must have been
+// escaped with JSONEscape. The result must be valid json.
+func RenderJSON(name, text string, values interface{}) (json.RawMessage, error) {
+	data, err := RenderText(name, text, values)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(data)) {
+		return nil, Error(fmt.Sprintf("Template %s renders invalid json data", name))
+	}
+	return json.RawMessage(data), nil
+}
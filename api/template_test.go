@@ -0,0 +1,32 @@
+package pub
+
+import "testing"
+
+func TestTemplateRender(t *testing.T) {
+	tmpl := &TaskTemplate{
+		Name:       "deploy",
+		Data:       []byte(`{"branch": "{{.branch}}", "count": {{.count}}}`),
+		PreScript:  "git checkout {{.branch}}",
+		PostScript: "echo done {{.count}}",
+		Hosts:      []string{"web-{{.count}}"},
+	}
+	for _, c := range []struct {
+		branch                      string
+		data, preScript, postScript string
+	}{
+		{"main", `{"branch": "main", "count": 2}`, "git checkout 'main'", "echo done 2"},
+		{"x; reboot", `{"branch": "x; reboot", "count": 2}`, "git checkout 'x; reboot'", "echo done 2"},
+		{`it's "$(reboot)"`, `{"branch": "it's \"$(reboot)\"", "count": 2}`, `git checkout 'it'\''s "$(reboot)"'`, "echo done 2"},
+	} {
+		rendered, err := tmpl.Render(map[string]interface{}{"branch": c.branch, "count": 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rendered.Data) != c.data || rendered.PreScript != c.preScript || rendered.PostScript != c.postScript {
+			t.Errorf("Render(%q) = %s, %q, %q, want %s, %q, %q", c.branch, rendered.Data, rendered.PreScript, rendered.PostScript, c.data, c.preScript, c.postScript)
+		}
+		if len(rendered.Hosts) != 1 || rendered.Hosts[0] != "web-2" {
+			t.Errorf("Render(%q) hosts = %v, want [web-2]", c.branch, rendered.Hosts)
+		}
+	}
+}
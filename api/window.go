@@ -0,0 +1,54 @@
+package pub
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// WindowAllow windows are the only time tasks may run on their hostgroups.
+	WindowAllow = "allow"
+	// WindowFreeze windows block tasks entirely, like a holiday freeze.
+	WindowFreeze = "freeze"
+)
+
+// MaintenanceWindow applies to the hosts of `HostgroupIDs`, or to every host when empty.
+// It recurs by `Spec` for `Duration` (like "2h") in `Timezone`, or spans from `Start` to `End`.
+type MaintenanceWindow struct {
+	ID           uint64    `json:"id"`
+	Name         string    `json:"name" binding:"required"`
+	Mode         string    `json:"mode" binding:"required"`
+	HostgroupIDs []uint64  `json:"hostgroup_ids,omitempty"`
+	Spec         string    `json:"spec,omitempty"`
+	Duration     string    `json:"duration,omitempty"`
+	Timezone     string    `json:"timezone,omitempty"`
+	Start        time.Time `json:"start,omitempty"`
+	End          time.Time `json:"end,omitempty"`
+	Comment      string    `json:"comment"`
+	Created      time.Time `json:"created"`
+}
+
+func (*MaintenanceWindow) UniqueFields() []string {
+	return []string{"ID", "Name"}
+}
+
+// WindowOverride records an administrator running a task regardless of the windows.
+type WindowOverride struct {
+	UserID uint64    `json:"user_id"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// WindowViolation is returned by WindowChecker when a task may not run now,
+// `Window` is nil when the hostgroup is outside all of its allow windows.
+type WindowViolation struct {
+	Window      *MaintenanceWindow
+	HostgroupID uint64
+}
+
+func (v *WindowViolation) Error() string {
+	if v.Window == nil {
+		return fmt.Sprintf("Hostgroup #%d is outside of its maintenance windows", v.HostgroupID)
+	}
+	return fmt.Sprintf("Hostgroup #%d is frozen by window #%d (%s)", v.HostgroupID, v.Window.ID, v.Window.Name)
+}
@@ -0,0 +1,124 @@
+package window
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+	"github.com/robfig/cron"
+)
+
+// Service checks the hostgroups of a task against the windows kept in WindowService.
+// An active freeze window blocks the task. A hostgroup with allow windows, its own
+// or global ones, only accepts tasks while one of them is active.
+type Service struct {
+	WindowService pub.WindowService
+}
+
+// Validate checks that the window is either recurring or absolute and can be evaluated.
+func Validate(w *pub.MaintenanceWindow) error {
+	if w.Mode != pub.WindowAllow && w.Mode != pub.WindowFreeze {
+		return pub.Error(fmt.Sprintf("Window mode must be %s or %s", pub.WindowAllow, pub.WindowFreeze))
+	}
+	if w.Spec != "" {
+		if !w.Start.IsZero() || !w.End.IsZero() {
+			return pub.Error("Window has either spec and duration or start and end")
+		}
+		if _, err := cron.Parse(w.Spec); err != nil {
+			return pub.Error(fmt.Sprintf("Invalid spec %q: %s", w.Spec, err))
+		}
+		if d, err := time.ParseDuration(w.Duration); err != nil || d <= 0 {
+			return pub.Error(fmt.Sprintf("Invalid duration %q", w.Duration))
+		}
+		if _, err := time.LoadLocation(w.Timezone); err != nil {
+			return pub.Error(fmt.Sprintf("Invalid timezone %q", w.Timezone))
+		}
+		return nil
+	}
+	if w.Start.IsZero() || !w.End.After(w.Start) {
+		return pub.Error("Window requires spec and duration, or start before end")
+	}
+	return nil
+}
+
+// Active reports whether the window is open at the given time.
+func Active(w *pub.MaintenanceWindow, at time.Time) (bool, error) {
+	if w.Spec == "" {
+		return !at.Before(w.Start) && at.Before(w.End), nil
+	}
+	schedule, err := cron.Parse(w.Spec)
+	if err != nil {
+		return false, err
+	}
+	d, err := time.ParseDuration(w.Duration)
+	if err != nil {
+		return false, err
+	}
+	loc := time.Local
+	if w.Timezone != "" {
+		if loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return false, err
+		}
+	}
+	// the window is open when it has been opened within the last `Duration`.
+	return !schedule.Next(at.Add(-d).In(loc)).After(at), nil
+}
+
+// Check evaluates the windows of each hostgroup at the given time,
+// a rejection is reported as *pub.WindowViolation.
+func (s *Service) Check(hostgroupIDs []uint64, at time.Time) error {
+	windows, err := s.WindowService.Windows()
+	if err == pub.ErrWindowSetEmpty {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(hostgroupIDs) == 0 {
+		hostgroupIDs = []uint64{0}
+	}
+	for _, hostgroupID := range hostgroupIDs {
+		if err = evaluate(windows, hostgroupID, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func evaluate(windows []pub.MaintenanceWindow, hostgroupID uint64, at time.Time) error {
+	var allowed, restricted bool
+	for i := range windows {
+		w := &windows[i]
+		if !applies(w, hostgroupID) {
+			continue
+		}
+		active, err := Active(w, at)
+		if err != nil {
+			return err
+		}
+		switch w.Mode {
+		case pub.WindowFreeze:
+			if active {
+				return &pub.WindowViolation{Window: w, HostgroupID: hostgroupID}
+			}
+		case pub.WindowAllow:
+			restricted = true
+			allowed = allowed || active
+		}
+	}
+	if restricted && !allowed {
+		return &pub.WindowViolation{HostgroupID: hostgroupID}
+	}
+	return nil
+}
+
+func applies(w *pub.MaintenanceWindow, hostgroupID uint64) bool {
+	if len(w.HostgroupIDs) == 0 {
+		return true
+	}
+	for _, id := range w.HostgroupIDs {
+		if id == hostgroupID {
+			return true
+		}
+	}
+	return false
+}
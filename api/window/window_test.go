@@ -0,0 +1,122 @@
+package window
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fengxsong/pubmgmt/api"
+)
+
+// windowStore serves the windows, the other methods of the service are not implemented.
+type windowStore struct {
+	pub.WindowService
+	windows []pub.MaintenanceWindow
+}
+
+func (s *windowStore) Windows() ([]pub.MaintenanceWindow, error) {
+	if len(s.windows) == 0 {
+		return nil, pub.ErrWindowSetEmpty
+	}
+	return s.windows, nil
+}
+
+func utc(value string) time.Time {
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return at
+}
+
+func TestActive(t *testing.T) {
+	// every night from 02:00 to 04:00 in Shanghai, 18:00 to 20:00 UTC.
+	shanghai := pub.MaintenanceWindow{Spec: "0 0 2 * * *", Duration: "2h", Timezone: "Asia/Shanghai"}
+	// from 23:00 to 01:00 in Berlin, across midnight, 21:00 to 23:00 UTC in summer.
+	berlin := pub.MaintenanceWindow{Spec: "0 0 23 * * *", Duration: "2h", Timezone: "Europe/Berlin"}
+	// on weekdays from 09:00 to 17:00 in New York.
+	weekdays := pub.MaintenanceWindow{Spec: "0 0 9 * * 1-5", Duration: "8h", Timezone: "America/New_York"}
+	absolute := pub.MaintenanceWindow{Start: utc("2024-12-20T00:00:00Z"), End: utc("2025-01-02T00:00:00Z")}
+	for _, c := range []struct {
+		name   string
+		window pub.MaintenanceWindow
+		at     string
+		active bool
+	}{
+		{"opens on time", shanghai, "2024-06-03T18:00:00Z", true},
+		{"open", shanghai, "2024-06-03T19:30:00Z", true},
+		{"closes on time", shanghai, "2024-06-03T20:00:00Z", false},
+		{"02:30 in UTC is not 02:30 in Shanghai", shanghai, "2024-06-04T02:30:00Z", false},
+		{"before midnight", berlin, "2024-06-03T21:30:00Z", true},
+		{"after midnight", berlin, "2024-06-03T22:30:00Z", true},
+		{"after the window", berlin, "2024-06-03T23:00:00Z", false},
+		{"winter time", berlin, "2024-12-03T22:30:00Z", true},
+		{"winter time, an hour later", berlin, "2024-12-04T00:30:00Z", false},
+		{"monday in New York", weekdays, "2024-06-03T14:00:00Z", true},
+		{"monday evening in New York, tuesday in UTC", weekdays, "2024-06-04T00:30:00Z", false},
+		{"saturday in New York", weekdays, "2024-06-08T14:00:00Z", false},
+		{"absolute start", absolute, "2024-12-20T00:00:00Z", true},
+		{"absolute end", absolute, "2025-01-02T00:00:00Z", false},
+		{"before the absolute start", absolute, "2024-12-19T23:59:59Z", false},
+	} {
+		active, err := Active(&c.window, utc(c.at))
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if active != c.active {
+			t.Errorf("%s: Active at %s = %v, want %v", c.name, c.at, active, c.active)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var (
+		// 18:00 to 20:00 UTC.
+		nightly = pub.MaintenanceWindow{ID: 1, Name: "nightly", Mode: pub.WindowAllow, HostgroupIDs: []uint64{1}, Spec: "0 0 18 * * *", Duration: "2h", Timezone: "UTC"}
+		// 12:00 to 13:00 UTC.
+		lunch = pub.MaintenanceWindow{ID: 2, Name: "lunch", Mode: pub.WindowAllow, HostgroupIDs: []uint64{1}, Spec: "0 0 12 * * *", Duration: "1h", Timezone: "UTC"}
+		// 19:00 to 19:30 UTC, within the nightly window.
+		release  = pub.MaintenanceWindow{ID: 3, Name: "release", Mode: pub.WindowFreeze, HostgroupIDs: []uint64{1}, Spec: "0 0 19 * * *", Duration: "30m", Timezone: "UTC"}
+		holidays = pub.MaintenanceWindow{ID: 4, Name: "holidays", Mode: pub.WindowFreeze, Start: utc("2024-12-24T00:00:00Z"), End: utc("2024-12-27T00:00:00Z")}
+		// 08:00 to 18:00 UTC for every hostgroup.
+		office = pub.MaintenanceWindow{ID: 5, Name: "office", Mode: pub.WindowAllow, Spec: "0 0 8 * * *", Duration: "10h", Timezone: "UTC"}
+	)
+	for _, c := range []struct {
+		name         string
+		windows      []pub.MaintenanceWindow
+		hostgroupIDs []uint64
+		at           string
+		allowed      bool
+		frozenBy     uint64
+	}{
+		{"no window", nil, []uint64{1}, "2024-06-03T10:00:00Z", true, 0},
+		{"inside an allow window", []pub.MaintenanceWindow{nightly}, []uint64{1}, "2024-06-03T18:30:00Z", true, 0},
+		{"outside of the allow windows", []pub.MaintenanceWindow{nightly, lunch}, []uint64{1}, "2024-06-03T10:00:00Z", false, 0},
+		{"inside one of the allow windows", []pub.MaintenanceWindow{nightly, lunch}, []uint64{1}, "2024-06-03T12:30:00Z", true, 0},
+		{"another hostgroup", []pub.MaintenanceWindow{nightly}, []uint64{2}, "2024-06-03T10:00:00Z", true, 0},
+		{"one of the hostgroups outside", []pub.MaintenanceWindow{nightly}, []uint64{2, 1}, "2024-06-03T10:00:00Z", false, 0},
+		{"a freeze overlaps the allow window", []pub.MaintenanceWindow{nightly, release}, []uint64{1}, "2024-06-03T19:10:00Z", false, 3},
+		{"after the freeze", []pub.MaintenanceWindow{nightly, release}, []uint64{1}, "2024-06-03T19:30:00Z", true, 0},
+		{"a global freeze", []pub.MaintenanceWindow{nightly, holidays}, []uint64{1}, "2024-12-25T18:30:00Z", false, 4},
+		{"a global freeze without hostgroup", []pub.MaintenanceWindow{holidays}, nil, "2024-12-25T10:00:00Z", false, 4},
+		{"global and own allow windows", []pub.MaintenanceWindow{nightly, office}, []uint64{1}, "2024-06-03T10:00:00Z", true, 0},
+		{"outside of the global allow window", []pub.MaintenanceWindow{office}, []uint64{2}, "2024-06-03T20:00:00Z", false, 0},
+		{"own windows apply only to the hostgroup", []pub.MaintenanceWindow{nightly}, nil, "2024-06-03T10:00:00Z", true, 0},
+	} {
+		s := &Service{WindowService: &windowStore{windows: c.windows}}
+		err := s.Check(c.hostgroupIDs, utc(c.at))
+		if c.allowed {
+			if err != nil {
+				t.Errorf("%s: Check = %s, want allowed", c.name, err)
+			}
+			continue
+		}
+		v, ok := err.(*pub.WindowViolation)
+		if !ok {
+			t.Errorf("%s: Check = %v, want a window violation", c.name, err)
+			continue
+		}
+		if c.frozenBy == 0 && v.Window != nil || c.frozenBy != 0 && (v.Window == nil || v.Window.ID != c.frozenBy) {
+			t.Errorf("%s: Check = %s, want frozen by #%d", c.name, v, c.frozenBy)
+		}
+	}
+}
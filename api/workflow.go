@@ -0,0 +1,183 @@
+package pub
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Workflow step types.
+const (
+	// StepTask runs a module on the hosts of the step over ssh.
+	StepTask = "task"
+	// StepHTTP requests `URL` from the pubmgmt server, like a smoke test.
+	StepHTTP = "http"
+)
+
+// Workflow run and step statuses.
+const (
+	WorkflowRunning   = "running"
+	WorkflowSucceeded = "succeeded"
+	WorkflowFailed    = "failed"
+	WorkflowSkipped   = "skipped"
+)
+
+// Workflow is a DAG of steps starting at `Entry`, the first step when empty.
+// After a step the run goes on with its `OnSuccess` or `OnFailure` step, and it
+// ends when there is none. A run succeeds when none of its steps failed.
+//
+// The data, hosts and url of a step are rendered with text/template before the
+// step runs, with `.Vars` and the previous steps as `.Steps.<name>`, for instance
+// `{{.Steps.build.Stdout}}`.
+type Workflow struct {
+	ID        uint64            `json:"id"`
+	Name      string            `json:"name" binding:"required"`
+	Entry     string            `json:"entry,omitempty"`
+	Steps     []WorkflowStep    `json:"steps" binding:"required"`
+	Vars      map[string]string `json:"vars,omitempty"`
+	Spec      string            `json:"spec,omitempty"`
+	Timezone  string            `json:"timezone,omitempty"`
+	Overlap   string            `json:"overlap,omitempty"`
+	Suspended bool              `json:"suspended"`
+	UserID    uint64            `json:"user_id"`
+	Created   time.Time         `json:"created"`
+	Updated   time.Time         `json:"updated"`
+}
+
+type WorkflowStep struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type,omitempty"`
+	Module     string          `json:"module,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	PreScript  string          `json:"pre_script,omitempty"`
+	PostScript string          `json:"post_script,omitempty"`
+	Hosts      []string        `json:"hosts,omitempty"`
+	URL        string          `json:"url,omitempty"`
+	// ExpectStatus is the http status a StepHTTP expects, any status below 400 when zero.
+	ExpectStatus int          `json:"expect_status,omitempty"`
+	Retry        *RetryPolicy `json:"retry,omitempty"`
+	OnSuccess    string       `json:"on_success,omitempty"`
+	OnFailure    string       `json:"on_failure,omitempty"`
+}
+
+// WorkflowRun is the record of a single execution of a workflow.
+type WorkflowRun struct {
+	ID         uint64            `json:"id"`
+	WorkflowID uint64            `json:"workflow_id"`
+	Status     string            `json:"status"`
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	Vars       map[string]string `json:"vars,omitempty"`
+	Steps      []WorkflowStepRun `json:"steps"`
+	Override   *WindowOverride   `json:"override,omitempty"`
+	Err        string            `json:"error,omitempty"`
+}
+
+// WorkflowStepRun is the result of a step, `Stdout` is the output of its last host or the http body.
+type WorkflowStepRun struct {
+	Name     string            `json:"name"`
+	Status   string            `json:"status"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Stdout   string            `json:"stdout"`
+	Hosts    map[string]string `json:"hosts,omitempty"`
+	Attempts []RunAttempt      `json:"attempts,omitempty"`
+	Err      string            `json:"error,omitempty"`
+}
+
+func (*Workflow) UniqueFields() []string {
+	return []string{"ID", "Name"}
+}
+
+func (*WorkflowRun) UniqueFields() []string {
+	return []string{"ID"}
+}
+
+// Step returns the step by its name, nil if there is none.
+func (w *Workflow) Step(name string) *WorkflowStep {
+	for i := range w.Steps {
+		if w.Steps[i].Name == name {
+			return &w.Steps[i]
+		}
+	}
+	return nil
+}
+
+// EntryStep is the step a run starts with.
+func (w *Workflow) EntryStep() *WorkflowStep {
+	if w.Entry == "" && len(w.Steps) > 0 {
+		return &w.Steps[0]
+	}
+	return w.Step(w.Entry)
+}
+
+// Validate checks the steps and makes sure their edges make up a DAG.
+func (w *Workflow) Validate() error {
+	if len(w.Steps) == 0 {
+		return Error("Workflow has no steps")
+	}
+	names := make(map[string]bool)
+	for i := range w.Steps {
+		s := &w.Steps[i]
+		if s.Name == "" || names[s.Name] {
+			return Error(fmt.Sprintf("Step #%d requires an unique name", i))
+		}
+		names[s.Name] = true
+		switch s.Type {
+		case "", StepTask:
+			if s.Module == "" || len(s.Hosts) == 0 {
+				return Error(fmt.Sprintf("Step %s requires module and hosts", s.Name))
+			}
+		case StepHTTP:
+			if s.URL == "" {
+				return Error(fmt.Sprintf("Step %s requires url", s.Name))
+			}
+		default:
+			return Error(fmt.Sprintf("Step %s: type %s is not supported", s.Name, s.Type))
+		}
+		if s.Retry != nil {
+			if err := s.Retry.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	if w.EntryStep() == nil {
+		return Error(fmt.Sprintf("Entry step %s not found", w.Entry))
+	}
+	for i := range w.Steps {
+		for _, next := range []string{w.Steps[i].OnSuccess, w.Steps[i].OnFailure} {
+			if next != "" && !names[next] {
+				return Error(fmt.Sprintf("Step %s: next step %s not found", w.Steps[i].Name, next))
+			}
+		}
+	}
+	// 1: being visited, 2: done
+	state := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return Error(fmt.Sprintf("Workflow has a cycle through step %s", name))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		s := w.Step(name)
+		for _, next := range []string{s.OnSuccess, s.OnFailure} {
+			if next == "" {
+				continue
+			}
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for i := range w.Steps {
+		if err := visit(w.Steps[i].Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
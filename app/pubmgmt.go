@@ -8,6 +8,9 @@ import (
 	"github.com/fengxsong/pubmgmt/api/crypto"
 	"github.com/fengxsong/pubmgmt/api/http"
 	"github.com/fengxsong/pubmgmt/api/jwt"
+	"github.com/fengxsong/pubmgmt/api/policy"
+	"github.com/fengxsong/pubmgmt/api/window"
+	"github.com/fengxsong/pubmgmt/module"
 )
 
 func initStore(dataStorePath string) *bolt.Store {
@@ -37,20 +40,49 @@ func initCryptoService() pub.CryptoService {
 	return &crypto.Service{}
 }
 
+func initCommandChecker(policyService pub.PolicyService) pub.CommandChecker {
+	checker := &policy.Service{PolicyService: policyService}
+	if err := checker.Init(); err != nil {
+		log.Fatalln(err)
+	}
+	return checker
+}
+
+func initPlugins(pluginsPath string) {
+	if pluginsPath == "" {
+		return
+	}
+	names, skipped, err := module.LoadPlugins(pluginsPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, err := range skipped {
+		log.Warnf("Module plugin skipped: %s", err)
+	}
+	log.Infof("Loaded module plugins: %v", names)
+}
+
 func main() {
 	flags, _ := cli.ParseFlags()
 	store := initStore(*flags.Data)
+	initPlugins(*flags.Plugins)
 
 	server := http.Server{
-		Flags:         flags,
-		Logger:        log.New(),
-		CryptoService: initCryptoService(),
-		JWTService:    initJWTService(true),
-		UserService:   store.UserService,
-		HostService:   store.HostService,
-		MailerService: store.MailerService,
-		TaskService:   store.TaskService,
-		ModuleService: store.ModuleService,
+		Flags:           flags,
+		Logger:          log.New(),
+		CryptoService:   initCryptoService(),
+		JWTService:      initJWTService(true),
+		UserService:     store.UserService,
+		HostService:     store.HostService,
+		MailerService:   store.MailerService,
+		TaskService:     store.TaskService,
+		ModuleService:   store.ModuleService,
+		PolicyService:   store.PolicyService,
+		CommandChecker:  initCommandChecker(store.PolicyService),
+		WorkflowService: store.WorkflowService,
+		TemplateService: store.TemplateService,
+		WindowService:   store.WindowService,
+		WindowChecker:   &window.Service{WindowService: store.WindowService},
 	}
 	err := server.Start()
 	if err != nil {
@@ -0,0 +1,52 @@
+package helper
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The placeholders Become puts in a command, the ssh client replaces them with a
+// nonce of its own for every session: the prompt sudo asks the password with, and
+// the line printed before the command runs. A prompt is only answered before that
+// line, so a command can not fake the prompt to read the password.
+const (
+	BecomePrompt = "@PUBMGMT_BECOME_PROMPT@"
+	BecomeStart  = "@PUBMGMT_BECOME_START@"
+	suPrompt     = "assword:"
+)
+
+// Become wraps command to be executed as user through sudo or su.
+func Become(method, user, command string) string {
+	if user == "" {
+		user = "root"
+	}
+	command = "echo " + BecomeStart + "\n" + command
+	if method == "su" {
+		return fmt.Sprintf("su - %s -c %s", ShellQuote(user), ShellQuote(command))
+	}
+	return fmt.Sprintf("sudo -S -p %s -u %s -- sh -c %s", ShellQuote(BecomePrompt), ShellQuote(user), ShellQuote(command))
+}
+
+// ShellQuote quotes str as a single word for a POSIX shell.
+func ShellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
+}
+
+// BecomeSession replaces the placeholders of a command wrapped by Become with a new
+// nonce, it returns the command, the password prompt of the method and the start line.
+func BecomeSession(method, command string) (string, string, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	nonce := hex.EncodeToString(b)
+	prompt, start := "pubmgmt-prompt-"+nonce+":", "pubmgmt-start-"+nonce
+	command = strings.Replace(command, BecomePrompt, prompt, -1)
+	command = strings.Replace(command, BecomeStart, start, -1)
+	if method == "su" {
+		prompt = suPrompt
+	}
+	return command, prompt, start, nil
+}
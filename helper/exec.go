@@ -1,8 +1,11 @@
 package helper
 
 import (
+	"bytes"
 	"os/exec"
 	"strings"
+	"syscall"
+	"unicode/utf8"
 )
 
 type Cmd struct {
@@ -49,3 +52,31 @@ func Command(command string, a ...string) (output string, err error) {
 	}
 	return
 }
+
+// CommandResult runs command and returns its stdout, stderr and exit status.
+func CommandResult(command string, a ...string) (stdout, stderr string, rc int, err error) {
+	var outBuf, errBuf bytes.Buffer
+	cmd := exec.Command(command, a...)
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			rc = status.ExitStatus()
+		}
+	} else if err != nil {
+		rc = -1
+	}
+	return outBuf.String(), errBuf.String(), rc, err
+}
+
+// Truncate cuts str down to n bytes at most, on a rune boundary.
+func Truncate(str string, n int) string {
+	if len(str) <= n {
+		return str
+	}
+	for n > 0 && !utf8.RuneStart(str[n]) {
+		n--
+	}
+	return str[:n] + "...(truncated)"
+}
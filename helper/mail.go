@@ -0,0 +1,289 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// smtpTimeout bounds the connection to an smtp server.
+	smtpTimeout = 30 * time.Second
+	// smtpSessionTimeout bounds the whole session, from the greeting to QUIT.
+	smtpSessionTimeout = 5 * time.Minute
+)
+
+// TLS modes of an smtp server, auto upgrades the connection with STARTTLS when
+// the server offers it, starttls requires it and tls connects with TLS right away.
+const (
+	TLSAuto     = ""
+	TLSNone     = "none"
+	TLSStartTLS = "starttls"
+	TLSImplicit = "tls"
+)
+
+// Authentication mechanisms of an smtp server, no authentication is empty.
+const (
+	AuthNone    = ""
+	AuthPlain   = "plain"
+	AuthCRAMMD5 = "cram-md5"
+)
+
+// SMTPConfig is the smtp server a message is sent through, `From` is the sender
+// address, `Username` when it is empty.
+type SMTPConfig struct {
+	Host      string
+	Port      int
+	TLS       string
+	Auth      string
+	Username  string
+	Password  string
+	From      string
+	FromAlias string
+}
+
+// MailAttachment is a file attached to a message.
+type MailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// MailMessage is a message with a text body, an html body or both, and attachments.
+type MailMessage struct {
+	From        string
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []MailAttachment
+}
+
+// Bytes builds the MIME message, multipart/alternative for the bodies, within
+// multipart/mixed when there are attachments.
+func (m *MailMessage) Bytes() ([]byte, error) {
+	for _, v := range append([]string{m.From, m.Subject}, m.To...) {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errors.New("mail: line breaks are not allowed in headers")
+		}
+	}
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", m.From)
+	header.Set("To", strings.Join(m.To, ", "))
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("MIME-Version", "1.0")
+
+	bodyHeader, body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Attachments) == 0 {
+		for k, v := range bodyHeader {
+			header[k] = v
+		}
+		writeHeader(&buf, header)
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+	mixed := multipart.NewWriter(&buf)
+	header.Set("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	writeHeader(&buf, header)
+	part, err := mixed.CreatePart(bodyHeader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(body); err != nil {
+		return nil, err
+	}
+	for _, a := range m.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Transfer-Encoding", "base64")
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+		if part, err = mixed.CreatePart(h); err != nil {
+			return nil, err
+		}
+		if err = writeBase64(part, a.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err = mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// body returns the headers and the content of the bodies, a single one or multipart/alternative.
+func (m *MailMessage) body() (textproto.MIMEHeader, []byte, error) {
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	if m.HTML == "" || m.Text == "" {
+		content, contentType := m.Text, "text/plain; charset=utf-8"
+		if m.HTML != "" {
+			content, contentType = m.HTML, "text/html; charset=utf-8"
+		}
+		header.Set("Content-Type", contentType)
+		header.Set("Content-Transfer-Encoding", "base64")
+		err := writeBase64(&buf, []byte(content))
+		return header, buf.Bytes(), err
+	}
+	alternative := multipart.NewWriter(&buf)
+	header.Set("Content-Type", "multipart/alternative; boundary="+alternative.Boundary())
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", body.contentType)
+		h.Set("Content-Transfer-Encoding", "base64")
+		part, err := alternative.CreatePart(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = writeBase64(part, []byte(body.content)); err != nil {
+			return nil, nil, err
+		}
+	}
+	err := alternative.Close()
+	return header, buf.Bytes(), err
+}
+
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	for k, vs := range header {
+		for _, v := range vs {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+// writeBase64 writes data base64 encoded, in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := w.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := w.Write([]byte(encoded + "\r\n"))
+	return err
+}
+
+// SendMail sends the message through the smtp server, from the address of the config.
+func SendMail(cfg SMTPConfig, m *MailMessage) error {
+	sender := cfg.From
+	if sender == "" {
+		sender = cfg.Username
+	}
+	from := mail.Address{Name: cfg.FromAlias, Address: sender}
+	m.From = from.String()
+	data, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	c, err := dialSMTP(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err = authSMTP(c, cfg); err != nil {
+		return err
+	}
+	if err = c.Mail(sender); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// dialSMTP connects to the smtp server and negotiates TLS according to its mode.
+func dialSMTP(cfg SMTPConfig) (*smtp.Client, error) {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if cfg.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a server which stops answering must not hold the sender forever.
+	if err = conn.SetDeadline(time.Now().Add(smtpSessionTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if cfg.TLS == TLSNone || cfg.TLS == TLSImplicit {
+		return c, nil
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(tlsConfig)
+	} else if cfg.TLS == TLSStartTLS {
+		err = errors.New("mail: server does not support STARTTLS")
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func authSMTP(c *smtp.Client, cfg SMTPConfig) error {
+	var auth smtp.Auth
+	switch cfg.Auth {
+	case AuthNone:
+		return nil
+	case AuthPlain:
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	case AuthCRAMMD5:
+		auth = smtp.CRAMMD5Auth(cfg.Username, cfg.Password)
+	default:
+		return fmt.Errorf("mail: unknown authentication %q", cfg.Auth)
+	}
+	if ok, _ := c.Extension("AUTH"); !ok {
+		return errors.New("mail: server does not support authentication")
+	}
+	return c.Auth(auth)
+}
@@ -0,0 +1,170 @@
+package helper
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+type smtpMessage struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer listens on the loopback, it offers PLAIN authentication, accepts
+// any credentials and records the messages it receives.
+type smtpServer struct {
+	ln       net.Listener
+	messages chan smtpMessage
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, messages: make(chan smtpMessage, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) port() int { return s.ln.Addr().(*net.TCPAddr).Port }
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	tc := textproto.NewConn(conn)
+	var msg smtpMessage
+	tc.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250-localhost")
+			tc.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			msg.auth = line
+			tc.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			msg.from = strings.TrimPrefix(line, "MAIL FROM:")
+			tc.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.TrimPrefix(line, "RCPT TO:"))
+			tc.PrintfLine("250 OK")
+		case "DATA":
+			tc.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tc.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			tc.PrintfLine("250 OK")
+		case "QUIT":
+			tc.PrintfLine("221 Bye")
+			s.messages <- msg
+			return
+		default:
+			tc.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) message(t *testing.T) smtpMessage {
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return smtpMessage{}
+}
+
+func TestSendMail(t *testing.T) {
+	s := newSMTPServer(t)
+	defer s.ln.Close()
+	cfg := SMTPConfig{
+		Host:      "127.0.0.1",
+		Port:      s.port(),
+		Auth:      AuthPlain,
+		Username:  "pub@example.com",
+		Password:  "secret",
+		FromAlias: "pubmgmt",
+	}
+	m := &MailMessage{
+		To:      []string{"ops@example.com", "dev@example.com"},
+		Subject: "deploy done",
+		Text:    "all hosts are up to date",
+	}
+	if err := SendMail(cfg, m); err != nil {
+		t.Fatalf("SendMail: %s", err)
+	}
+	msg := s.message(t)
+
+	if want := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00pub@example.com\x00secret")); msg.auth != want {
+		t.Errorf("auth = %q, want %q", msg.auth, want)
+	}
+	if msg.from != "<pub@example.com>" {
+		t.Errorf("sender = %q, want <pub@example.com>", msg.from)
+	}
+	if strings.Join(msg.to, ",") != "<ops@example.com>,<dev@example.com>" {
+		t.Errorf("recipients = %v", msg.to)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(msg.data))
+	if err != nil {
+		t.Fatalf("invalid message: %s", err)
+	}
+	if got := parsed.Header.Get("From"); got != `"pubmgmt" <pub@example.com>` {
+		t.Errorf("From = %q", got)
+	}
+	if got := parsed.Header.Get("Subject"); got != "deploy done" {
+		t.Errorf("Subject = %q", got)
+	}
+	data, err := ioutil.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("body is not base64: %s", err)
+	}
+	if string(body) != m.Text {
+		t.Errorf("body = %q, want %q", body, m.Text)
+	}
+}
+
+func TestSendMailRequiresStartTLS(t *testing.T) {
+	s := newSMTPServer(t)
+	defer s.ln.Close()
+	cfg := SMTPConfig{Host: "127.0.0.1", Port: s.port(), TLS: TLSStartTLS, From: "pub@example.com"}
+	err := SendMail(cfg, &MailMessage{To: []string{"ops@example.com"}, Subject: "test", Text: "test"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("err = %v, want the server does not support STARTTLS", err)
+	}
+}
+
+func TestSendMailRejectsHeaderInjection(t *testing.T) {
+	s := newSMTPServer(t)
+	defer s.ln.Close()
+	cfg := SMTPConfig{Host: "127.0.0.1", Port: s.port(), From: "pub@example.com"}
+	err := SendMail(cfg, &MailMessage{To: []string{"ops@example.com"}, Subject: "test\r\nBcc: evil@example.com", Text: "test"})
+	if err == nil {
+		t.Fatal("a subject with a line break was sent")
+	}
+}
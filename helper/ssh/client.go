@@ -8,9 +8,11 @@ import (
 	"net"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/fengxsong/pubmgmt/api"
+	"github.com/fengxsong/pubmgmt/helper"
 	"github.com/fengxsong/pubmgmt/module"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -27,7 +29,13 @@ type Client struct {
 	Stderr         bytes.Buffer
 	ConnectRetries int
 	Timeout        int
-	cli            *ssh.Client
+	// Files are uploaded into a temporary directory which replaces module.FilesDir
+	// in the commands, the directory is removed after the run.
+	Files []module.File
+	// Become is the method the commands were wrapped with by helper.Become, its
+	// prompt is answered with the host's become secret. Empty when they were not.
+	Become string
+	cli    *ssh.Client
 }
 
 func (s *Client) getSSHKey(identityFile string) (key ssh.Signer, err error) {
@@ -106,35 +114,198 @@ func (s *Client) newSession() (*ssh.Session, error) {
 	return session, nil
 }
 
+// requestBecome allocates a pty for commands wrapped by helper.Become, the password
+// prompt is answered with the host's become secret. It is decided by the Become field,
+// never by the command which anybody may have typed.
+func (s *Client) requestBecome(session *ssh.Session, prompt, start string) error {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	session.Stdout = &promptWriter{
+		w:      &s.Stdout,
+		stdin:  stdin,
+		prompt: []byte(prompt),
+		start:  []byte(start),
+		answer: []byte(s.Host.BecomeSecret() + "\n"),
+	}
+	return nil
+}
+
+// promptWriter holds the output back until the start line of helper.Become shows up,
+// the prompt is answered once if it comes before it. Once the command runs nothing is
+// answered anymore, whatever it prints. The prompt and the start line are kept out of
+// the output.
+type promptWriter struct {
+	w        io.Writer
+	stdin    io.Writer
+	prompt   []byte
+	start    []byte
+	answer   []byte
+	buf      []byte
+	answered bool
+	started  bool
+}
+
+func (p *promptWriter) Write(b []byte) (int, error) {
+	if p.started {
+		return p.w.Write(b)
+	}
+	p.buf = append(p.buf, b...)
+	i := bytes.Index(p.buf, p.start)
+	if !p.answered {
+		// only what comes before the start line may be the prompt.
+		before := p.buf
+		if i >= 0 {
+			before = p.buf[:i]
+		}
+		if j := bytes.Index(before, p.prompt); j >= 0 {
+			p.answered = true
+			p.buf = append(p.buf[:j], p.buf[j+len(p.prompt):]...)
+			if i >= 0 {
+				i -= len(p.prompt)
+			}
+			if _, err := p.stdin.Write(p.answer); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if i < 0 {
+		return len(b), nil
+	}
+	p.started = true
+	rest := bytes.TrimLeft(p.buf[i+len(p.start):], "\r")
+	rest = bytes.TrimPrefix(rest, []byte("\n"))
+	if _, err := p.w.Write(append(p.buf[:i:i], rest...)); err != nil {
+		return 0, err
+	}
+	p.buf = nil
+	return len(b), nil
+}
+
+func (p *promptWriter) flush() {
+	p.w.Write(p.buf)
+	p.buf = nil
+}
+
 func (s *Client) exec(session *ssh.Session, c []string, resultChan chan *Result, block chan struct{}) {
 	defer session.Close()
 	var (
 		rc  int
 		err error
 	)
-	err = session.Run(c[1])
+	command := c[1]
+	if s.Become != "" {
+		var prompt, start string
+		command, prompt, start, err = helper.BecomeSession(s.Become, command)
+		if err == nil {
+			err = s.requestBecome(session, prompt, start)
+		}
+		if err != nil {
+			resultChan <- &Result{Stage: c[0], Err: err, RC: rc, Stdout: s.Stdout.String(), Stderr: s.Stderr.String()}
+			block <- struct{}{}
+			return
+		}
+	}
+	err = session.Run(command)
+	if pw, ok := session.Stdout.(*promptWriter); ok {
+		pw.flush()
+	}
 	if err != nil {
 		if err, ok := err.(*ssh.ExitError); ok {
 			rc = err.Waitmsg.ExitStatus()
 		}
 	}
-	resultChan <- &Result{c[0], err, rc, s.Stdout.String(), s.Stderr.String()}
+	resultChan <- &Result{Stage: c[0], Err: err, RC: rc, Stdout: s.Stdout.String(), Stderr: s.Stderr.String()}
 	block <- struct{}{}
 }
 
+// output runs command in a session of its own, its output is kept out of the client's.
+func (s *Client) output(command string) (string, error) {
+	if s.cli == nil {
+		return "", fmt.Errorf("Not connected")
+	}
+	session, err := s.cli.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.Output(command)
+	return strings.TrimSpace(string(out)), err
+}
+
+// upload copies the files into a new temporary directory on the host with Scp, they
+// are readable by the user a command becomes, as the command line used to be.
+func (s *Client) upload() (string, error) {
+	dir, err := s.output("d=$(mktemp -d /tmp/pubmgmt.XXXXXX) && chmod 755 \"$d\" && echo \"$d\"")
+	if err != nil {
+		return "", err
+	}
+	for _, f := range s.Files {
+		if err = s.uploadFile(dir, f); err != nil {
+			s.output("rm -rf " + helper.ShellQuote(dir))
+			return "", fmt.Errorf("Uploading %s: %s", f.Name, err)
+		}
+	}
+	return dir, nil
+}
+
+func (s *Client) uploadFile(dir string, f module.File) error {
+	tmp, err := ioutil.TempFile("", "pubmgmt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	_, err = tmp.WriteString(f.Content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return s.Scp(tmp.Name(), path.Join(dir, path.Base(f.Name)))
+}
+
 func (s *Client) Run(cmd module.Command) (result *Result) {
 	block := make(chan struct{}, 1)
-	for _, c := range cmd.Strings() {
+	stages := make(map[string]string)
+	defer func() { result.Stages = stages }()
+	commands := cmd.Strings()
+	if len(s.Files) > 0 {
+		dir, err := s.upload()
+		if err != nil {
+			return &Result{Stage: "Upload", Err: err}
+		}
+		defer s.output("rm -rf " + helper.ShellQuote(dir))
+		for i, c := range commands {
+			commands[i] = []string{c[0], strings.Replace(c[1], module.FilesDir, dir, -1)}
+		}
+	}
+	for _, c := range commands {
 		session, err := s.newSession()
 		if err != nil {
 			result = &Result{Err: err}
 			return
 		}
 		resultChan := make(chan *Result)
+		start := s.Stdout.Len()
 		go s.exec(session, c, resultChan, block)
 		select {
 		case result = <-resultChan:
 			<-block
+			stages[c[0]] = result.Stdout[start:]
 			if result.Err != nil {
 				return
 			}
@@ -152,6 +323,8 @@ func (s *Client) Scp(filePath, destPath string) error {
 		return err
 	}
 	defer session.Close()
+	// scp acknowledges with null bytes, they are not part of the output.
+	session.Stdout = nil
 	f, err := os.Open(filePath)
 	if err != nil {
 		return err
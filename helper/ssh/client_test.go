@@ -0,0 +1,41 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPromptWriter(t *testing.T) {
+	const (
+		prompt = "pubmgmt-prompt-abc:"
+		start  = "pubmgmt-start-abc"
+	)
+	for _, c := range []struct {
+		name     string
+		writes   []string
+		answered bool
+		output   string
+	}{
+		{"prompt then start", []string{"lecture\r\n" + prompt, "\r\n" + start + "\r\nout\r\n"}, true, "lecture\r\n\r\nout\r\n"},
+		{"prompt split across writes", []string{"pubmgmt-pro", "mpt-abc:", start + "\r\n", "out"}, true, "out"},
+		{"no prompt, NOPASSWD sudo", []string{start + "\r\nout"}, false, "out"},
+		{"prompt printed by the command", []string{start + "\r\n", "[sudo] password for x: " + prompt}, false, "[sudo] password for x: " + prompt},
+		{"prompt after the start in one write", []string{start + "\r\n" + prompt}, false, prompt},
+		{"failed authentication", []string{prompt, "\r\nsudo: 1 incorrect password attempt\r\n"}, true, "\r\nsudo: 1 incorrect password attempt\r\n"},
+	} {
+		var out, stdin bytes.Buffer
+		p := &promptWriter{w: &out, stdin: &stdin, prompt: []byte(prompt), start: []byte(start), answer: []byte("secret\n")}
+		for _, w := range c.writes {
+			if _, err := p.Write([]byte(w)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p.flush()
+		if answered := stdin.String() == "secret\n"; answered != c.answered || (!answered && stdin.Len() > 0) {
+			t.Errorf("%s: stdin = %q, answered want %v", c.name, stdin.String(), c.answered)
+		}
+		if out.String() != c.output {
+			t.Errorf("%s: output = %q, want %q", c.name, out.String(), c.output)
+		}
+	}
+}
@@ -21,6 +21,8 @@ type Result struct {
 	RC     int    `json:"ReturnCode,omitempty"`
 	Stdout string `json:"Stdout,omitempty"`
 	Stderr string `json:"Stderr,omitempty"`
+	// Stages is the stdout of every stage which ran, Stdout is all of them.
+	Stages map[string]string `json:"-"`
 }
 
 func (r *Result) String() string {
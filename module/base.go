@@ -22,7 +22,22 @@ func GetModules() []string {
 	return modules
 }
 
+// Validator is implemented by modules which check their data before building.
+type Validator interface {
+	Validate() error
+}
+
+// ResultParser is implemented by modules which turn the output into a structured result.
+type ResultParser interface {
+	Parse(stdout string) interface{}
+}
+
 func NewExecCommand(m Module) (*ExecCommand, error) {
+	if v, ok := m.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
 	return m.Build()
 }
 
@@ -30,11 +45,37 @@ type Command interface {
 	Strings() [][]string
 }
 
+// Escalation is embedded by modules which are able to run as another user, it is
+// carried by the task the command belongs to, which wraps every stage of it.
+type Escalation struct {
+	Become       bool   `json:"become"`
+	BecomeUser   string `json:"become_user,omitempty"`
+	BecomeMethod string `json:"become_method,omitempty"`
+}
+
+// FilesDir stands for the directory the files of a command are uploaded to on the host,
+// it is replaced in the command when it runs.
+const FilesDir = "@PUBMGMT_FILES@"
+
+// File is uploaded to the host before the command runs and removed after it.
+type File struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
 type ExecCommand struct {
+	Escalation
 	Environment []string         `json:"environment"`
 	Command     string           `json:"command"`
 	Arguments   []string         `json:"arguments"`
 	Abort       chan interface{} `json:"-"`
+	Files       []File           `json:"files,omitempty"`
+	// Sources are shell sources which end up executed without being part of
+	// the command line, they go through the command policies as well.
+	Sources []string `json:"-"`
+	// Timeout is the seconds the command needs to run at most, 0 when the default
+	// of the ssh client is enough.
+	Timeout int `json:"-"`
 }
 
 func (c *ExecCommand) buildCommand() string {
@@ -1,6 +1,7 @@
 package module
 
 type Git struct {
+	Escalation
 	Environment []string
 	Dest        string    `json:"dest"`
 	Repo        string    `json:"repo"`
@@ -14,6 +15,7 @@ type Git struct {
 
 func (g *Git) Build() (*ExecCommand, error) {
 	c := &ExecCommand{
+		Escalation:  g.Escalation,
 		Environment: g.Environment,
 		Command:     g.GitPath,
 	}
@@ -0,0 +1,136 @@
+package module
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Plugins are external executables living in the plugins directory.
+// pubmgmt talks to them with one JSON request on stdin and expects one
+// JSON response on stdout:
+//
+//	{"action": "describe"}
+//	{"action": "validate", "data": {...}}
+//	{"action": "build", "data": {...}}
+//
+// `describe` must answer with the module name, `validate` with an empty
+// error, and `build` with the command to execute on the remote hosts.
+const (
+	pluginDescribe = "describe"
+	pluginValidate = "validate"
+	pluginBuild    = "build"
+	pluginTimeout  = 30 * time.Second
+)
+
+type pluginRequest struct {
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+type pluginResponse struct {
+	Name        string       `json:"name,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Command     *ExecCommand `json:"command,omitempty"`
+}
+
+// Plugin is a Module backed by an external executable.
+type Plugin struct {
+	path string
+	name string
+	data json.RawMessage
+}
+
+// UnmarshalJSON keeps the raw task data, it is handed over to the plugin as is.
+func (p *Plugin) UnmarshalJSON(data []byte) error {
+	p.data = append(p.data[:0], data...)
+	return nil
+}
+
+func (p *Plugin) Name() string { return p.name }
+
+func (p *Plugin) Validate() error {
+	_, err := callPlugin(p.path, &pluginRequest{Action: pluginValidate, Data: p.data})
+	return err
+}
+
+func (p *Plugin) Build() (*ExecCommand, error) {
+	resp, err := callPlugin(p.path, &pluginRequest{Action: pluginBuild, Data: p.data})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Command == nil || resp.Command.Command == "" {
+		return nil, fmt.Errorf("Plugin %s returned an empty command", p.name)
+	}
+	return resp.Command, nil
+}
+
+func callPlugin(path string, req *pluginRequest) (*pluginResponse, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Plugin %s %s failed: %s %s", filepath.Base(path), req.Action, err, strings.TrimSpace(stderr.String()))
+	}
+	var resp pluginResponse
+	if err = json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("Plugin %s %s returned invalid json: %s", filepath.Base(path), req.Action, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// LoadPlugins describes every executable in dir and registers it in Modules, it
+// returns the names of the plugins loaded and why the others were skipped.
+// Built-in modules, and the plugins loaded first, win over a plugin with the
+// same name. Only a dir which can not be read fails.
+func LoadPlugins(dir string) ([]string, []error, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		names   []string
+		skipped []error
+	)
+	for _, f := range files {
+		if f.IsDir() || f.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		resp, err := callPlugin(path, &pluginRequest{Action: pluginDescribe})
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		name := strings.ToLower(resp.Name)
+		if name == "" {
+			skipped = append(skipped, fmt.Errorf("Plugin %s has no name", f.Name()))
+			continue
+		}
+		if _, ok := Modules[name]; ok {
+			skipped = append(skipped, fmt.Errorf("Plugin %s: module %s already registered", f.Name(), name))
+			continue
+		}
+		Modules[name] = func() Module { return &Plugin{path: path, name: name} }
+		names = append(names, name)
+	}
+	return names, skipped, nil
+}
@@ -0,0 +1,67 @@
+package module
+
+import (
+	"fmt"
+
+	"github.com/fengxsong/pubmgmt/helper"
+)
+
+// interpreters a script runs with. Shell scripts are checked against the command
+// policies like the command line, the other languages can not be: a python or perl
+// script only runs where a policy allows the interpreter program itself.
+var interpreters = map[string]string{
+	"bash":   "bash",
+	"sh":     "sh",
+	"python": "python",
+	"perl":   "perl",
+}
+
+var shellInterpreters = []string{"bash", "sh"}
+
+// Script uploads `Body` into a temporary directory on the host, runs it with
+// the interpreter and removes the directory when the run is over.
+type Script struct {
+	Escalation
+	Environment []string
+	Interpreter string   `json:"interpreter"`
+	Body        string   `json:"body"`
+	Arguments   []string `json:"arguments"`
+}
+
+func (s *Script) Name() string { return "script" }
+
+func (s *Script) Validate() error {
+	if s.Interpreter == "" {
+		s.Interpreter = "bash"
+	}
+	if _, ok := interpreters[s.Interpreter]; !ok {
+		return fmt.Errorf("Interpreter %s is not supported, expected bash, sh, python or perl", s.Interpreter)
+	}
+	if s.Body == "" {
+		return fmt.Errorf("Script body is empty")
+	}
+	return nil
+}
+
+// Build uploads the body as a file of the command, so heredocs and quoting in
+// the script never meet the remote shell and its size is not bound by the command line.
+func (s *Script) Build() (*ExecCommand, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	c := &ExecCommand{
+		Escalation:  s.Escalation,
+		Environment: s.Environment,
+		Command:     fmt.Sprintf("%s %s/script", interpreters[s.Interpreter], FilesDir),
+		Arguments:   s.Arguments,
+		Files:       []File{{Name: "script", Content: s.Body}},
+	}
+	if helper.Contains(shellInterpreters, s.Interpreter) {
+		c.Sources = []string{s.Body}
+	}
+	return c, nil
+}
+
+func init() {
+	Modules["script"] = func() Module { return &Script{} }
+}
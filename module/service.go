@@ -0,0 +1,134 @@
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fengxsong/pubmgmt/helper"
+)
+
+// unitPlaceholder is replaced with the quoted unit in the commands of the service
+// module, the unit is written in each command so the command policies see it.
+const unitPlaceholder = "@UNIT@"
+
+var serviceActions = map[string][2]string{
+	// action: {systemd, sysv}
+	"start":   {`systemctl start @UNIT@`, `service @UNIT@ start`},
+	"stop":    {`systemctl stop @UNIT@`, `service @UNIT@ stop`},
+	"restart": {`systemctl restart @UNIT@`, `service @UNIT@ restart`},
+	"reload":  {`systemctl reload @UNIT@`, `service @UNIT@ reload`},
+	"status":  {`:`, `:`},
+	"enable":  {`systemctl enable @UNIT@`, `if command -v chkconfig >/dev/null 2>&1; then chkconfig @UNIT@ on; else update-rc.d @UNIT@ defaults; fi`},
+	"disable": {`systemctl disable @UNIT@`, `if command -v chkconfig >/dev/null 2>&1; then chkconfig @UNIT@ off; else update-rc.d -f @UNIT@ remove; fi`},
+}
+
+const (
+	serviceDetect = `if command -v systemctl >/dev/null 2>&1 && [ -d /run/systemd/system ]; then manager=systemd; else manager=sysv; fi`
+	systemdActive = `systemctl is-active --quiet @UNIT@`
+	sysvActive    = `service @UNIT@ status >/dev/null 2>&1`
+	systemdStatus = `systemctl show --no-pager -p ActiveState -p SubState -p MainPID -p ActiveEnterTimestamp @UNIT@`
+	sysvStatus    = `if service @UNIT@ status >/dev/null 2>&1; then echo ActiveState=active; else echo ActiveState=inactive; fi
+pid=$(pidof @UNIT@ 2>/dev/null | cut -d' ' -f1)
+echo MainPID=${pid:-0}
+[ -n "$pid" ] && echo ActiveEnterTimestamp=$(ps -o lstart= -p "$pid")`
+)
+
+// Service manages a service with systemd or SysV init scripts, whichever the host runs.
+// The status of the service is printed after every action.
+type Service struct {
+	Escalation
+	Environment []string
+	Unit        string `json:"name"`
+	Action      string `json:"action"`
+	// Wait until the service is active, at most `Timeout` seconds.
+	// It is ignored by stop and disable.
+	Wait    bool `json:"wait"`
+	Timeout int  `json:"timeout"`
+}
+
+const (
+	// maxServiceWait is the longest wait for a service to be active.
+	maxServiceWait = 600
+	// serviceSlack is given to the action and the status on top of the wait.
+	serviceSlack = 60
+)
+
+func (s *Service) Name() string { return "service" }
+
+func (s *Service) Validate() error {
+	if s.Unit == "" {
+		return fmt.Errorf("Service name is empty")
+	}
+	if _, ok := serviceActions[s.Action]; !ok {
+		return fmt.Errorf("Service action %s is not supported", s.Action)
+	}
+	if s.Timeout > maxServiceWait {
+		return fmt.Errorf("Service timeout is longer than %ds", maxServiceWait)
+	}
+	return nil
+}
+
+func (s *Service) Build() (*ExecCommand, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = 30
+	}
+	action := serviceActions[s.Action]
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", serviceDetect)
+	fmt.Fprintf(&buf, "if [ $manager = systemd ]; then %s; else %s; fi || exit $?\n", action[0], action[1])
+	if s.Wait && s.Action != "stop" && s.Action != "disable" {
+		fmt.Fprintf(&buf, "i=0; until if [ $manager = systemd ]; then %s; else %s; fi; do\n", systemdActive, sysvActive)
+		fmt.Fprintf(&buf, "  i=$((i+1)); if [ $i -gt %d ]; then echo %s is not active after %ds >&2; exit 1; fi; sleep 1\ndone\n", s.Timeout, unitPlaceholder, s.Timeout)
+	}
+	fmt.Fprintf(&buf, "echo Manager=$manager\nif [ $manager = systemd ]; then %s; else\n%s\nfi\ntrue", systemdStatus, sysvStatus)
+	c := &ExecCommand{
+		Escalation:  s.Escalation,
+		Environment: s.Environment,
+		Command:     strings.Replace(buf.String(), unitPlaceholder, helper.ShellQuote(s.Unit), -1),
+	}
+	if s.Wait {
+		c.Timeout = s.Timeout + serviceSlack
+	}
+	return c, nil
+}
+
+// ServiceStatus is the structured status printed by the service module.
+type ServiceStatus struct {
+	Manager     string `json:"manager"`
+	ActiveState string `json:"active_state"`
+	SubState    string `json:"sub_state,omitempty"`
+	PID         int    `json:"pid"`
+	Since       string `json:"since,omitempty"`
+}
+
+func (s *Service) Parse(stdout string) interface{} {
+	status := &ServiceStatus{}
+	for _, line := range strings.Split(stdout, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Manager":
+			status.Manager = kv[1]
+		case "ActiveState":
+			status.ActiveState = kv[1]
+		case "SubState":
+			status.SubState = kv[1]
+		case "MainPID":
+			status.PID, _ = strconv.Atoi(kv[1])
+		case "ActiveEnterTimestamp":
+			status.Since = kv[1]
+		}
+	}
+	return status
+}
+
+func init() {
+	Modules["service"] = func() Module { return &Service{} }
+}
@@ -23,6 +23,7 @@ var SvnEventTypes = map[EventType]string{
 }
 
 type Subversion struct {
+	Escalation
 	Environment []string
 	Dest        string    `json:"dest"`
 	Repo        string    `json:"repo"`
@@ -41,6 +42,7 @@ func (s *Subversion) Build() (*ExecCommand, error) {
 		s.SvnPath = "/usr/bin/svn"
 	}
 	c := &ExecCommand{
+		Escalation:  s.Escalation,
 		Environment: s.Environment,
 		Command:     s.SvnPath,
 	}